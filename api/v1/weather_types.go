@@ -0,0 +1,558 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Weather providers accepted in spec.provider and spec.providers
+const (
+	ProviderOpenWeatherMap = "openweathermap"
+	ProviderNWS            = "nws"
+	ProviderOpenMeteo      = "openmeteo"
+)
+
+// Defaults applied to WeatherSpec
+const (
+	DefaultProvider      = ProviderOpenWeatherMap
+	DefaultUnits         = UnitsImperial
+	DefaultRefreshPeriod = "5m"
+	DefaultSecretKey     = "token"
+)
+
+// Bounds of spec.forecast.periods
+const (
+	DefaultForecastPeriods = 8
+	MaxForecastPeriods     = 40
+)
+
+// Bounds of spec.history.samples
+const (
+	DefaultHistorySamples = 24
+	MaxHistorySamples     = 100
+)
+
+// MaxAlerts bounds status.alerts
+const MaxAlerts = 20
+
+// Measurements a threshold can watch
+const (
+	MeasurementTemp      = "temp"
+	MeasurementPressure  = "pressure"
+	MeasurementHumidity  = "humidity"
+	MeasurementWindSpeed = "windSpeed"
+	MeasurementWindGust  = "windGust"
+	// Air quality measurements, reported when spec.airQuality is set
+	MeasurementAQI  = "aqi"
+	MeasurementCO   = "co"
+	MeasurementNO2  = "no2"
+	MeasurementO3   = "o3"
+	MeasurementSO2  = "so2"
+	MeasurementPM25 = "pm2_5"
+	MeasurementPM10 = "pm10"
+)
+
+// Threshold operators
+const (
+	ThresholdAbove = "above"
+	ThresholdBelow = "below"
+)
+
+// MinRefreshPeriod is the shortest spec.refreshPeriod accepted, to protect provider API quotas
+const MinRefreshPeriod = time.Minute
+
+// Unit systems, named after the OpenWeatherMap `units` parameter
+const (
+	UnitsImperial = "imperial"
+	UnitsMetric   = "metric"
+	UnitsStandard = "standard"
+)
+
+// Measurement units reported in WeatherStatus
+const (
+	UnitFahrenheit      = "degF"
+	UnitCelsius         = "degC"
+	UnitKelvin          = "K"
+	UnitMilesPerHour    = "mph"
+	UnitMetersPerSecond = "m/s"
+	UnitHectopascal     = "hPa"
+	UnitPercent         = "%"
+	UnitMeters          = "m"
+	UnitMillimeters     = "mm"
+	// UnitMicrogramsPerCubicMeter is the unit of air pollutant concentrations
+	UnitMicrogramsPerCubicMeter = "ug/m3"
+)
+
+// Condition types reported in WeatherStatus.Conditions
+const (
+	// ConditionReady is True when the status holds the result of the latest refresh
+	ConditionReady = "Ready"
+	// ConditionSecretResolved is True when the provider API token was read (or the provider needs none)
+	ConditionSecretResolved = "SecretResolved"
+	// ConditionProviderReachable is True when the last call to the weather provider succeeded
+	ConditionProviderReachable = "ProviderReachable"
+	// ConditionStale is True when the latest refresh failed, so the measurements are out of date
+	ConditionStale = "Stale"
+	// ConditionAlertActive is True while severe weather alerts are active for the location (with spec.alerts)
+	ConditionAlertActive = "AlertActive"
+	// ConditionThresholdBreached is True while any of spec.thresholds is breached
+	ConditionThresholdBreached = "ThresholdBreached"
+)
+
+// SecretRefSpec references the secret holding the provider API token
+type SecretRefSpec struct {
+	Name string `json:"name"`
+	// Key is the secret data key holding the token
+	//+kubebuilder:default=token
+	//+optional
+	Key string `json:"key,omitempty"`
+	// Namespace of the secret, defaulting to the Weather's namespace.
+	// Other namespaces must be allowed with the operator's --secret-namespaces flag.
+	//+optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// ProviderRef is one weather provider of spec.providers, with the secret holding its API token
+type ProviderRef struct {
+	// Name is the upstream weather service
+	//+kubebuilder:validation:Enum=openweathermap;nws;openmeteo
+	Name string `json:"name"`
+	// SecretRef holds the provider API token; optional for providers that need no token
+	//+optional
+	SecretRef *SecretRefSpec `json:"secretRef,omitempty"`
+}
+
+// HistorySpec keeps recent observations in status.history
+type HistorySpec struct {
+	// Samples is how many observations are kept in status.history, the oldest being dropped first
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=100
+	//+kubebuilder:default=24
+	//+optional
+	Samples int `json:"samples,omitempty"`
+}
+
+// ForecastSpec requests a forecast alongside the current conditions
+type ForecastSpec struct {
+	// Periods is how many forecast periods are kept in status.forecast. Periods are 3 hours long for
+	// openweathermap and 1 hour long for nws and openmeteo.
+	//+kubebuilder:validation:Minimum=1
+	//+kubebuilder:validation:Maximum=40
+	//+kubebuilder:default=8
+	//+optional
+	Periods int `json:"periods,omitempty"`
+}
+
+// Threshold is breached while a measurement is above (or below) a value. With hysteresis, a breach only
+// clears once the measurement is back below (or above) the value by more than the hysteresis.
+type Threshold struct {
+	// Name identifies the threshold in events and conditions
+	Name string `json:"name"`
+	// Measurement is the status measurement watched
+	//+kubebuilder:validation:Enum=temp;pressure;humidity;windSpeed;windGust;aqi;co;no2;o3;so2;pm2_5;pm10
+	Measurement string `json:"measurement"`
+	// Operator is above or below
+	//+kubebuilder:validation:Enum=above;below
+	Operator string `json:"operator"`
+	// Value is compared with the measurement in the weather's units
+	Value float64 `json:"value"`
+	// Hysteresis is how far the measurement must move back past Value for a breach to clear (at least 0)
+	//+optional
+	Hysteresis float64 `json:"hysteresis,omitempty"`
+}
+
+// SecretKeyRef references a key of a secret in the Weather's namespace
+type SecretKeyRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// NotifyTarget is an HTTP webhook that receives a JSON payload whenever the measurements change
+type NotifyTarget struct {
+	// Name identifies the target in status.notifications
+	Name string `json:"name"`
+	// URLSecretRef references the secret key holding the webhook URL, which often embeds a credential
+	URLSecretRef SecretKeyRef `json:"urlSecretRef"`
+	// HMACSecretRef references the secret key holding an HMAC key; when set, payloads are signed with
+	// HMAC-SHA256 in the X-Weather-Signature header as "sha256=<hex>"
+	//+optional
+	HMACSecretRef *SecretKeyRef `json:"hmacSecretRef,omitempty"`
+}
+
+// LocationSpec describes a place by name or postal code, to be geocoded into coordinates
+type LocationSpec struct {
+	// City name, e.g. "Culpeper"
+	//+optional
+	City string `json:"city,omitempty"`
+	// CountryCode is an ISO 3166 country code narrowing down the city or postal code, e.g. "US"
+	//+optional
+	CountryCode string `json:"countryCode,omitempty"`
+	// Zip is a postal code, used instead of the city
+	//+optional
+	Zip string `json:"zip,omitempty"`
+}
+
+// WeatherSpec defines the desired state of Weather
+type WeatherSpec struct {
+	// Lon and Lat are the coordinates of the weather, unless spec.location is set
+	//+optional
+	Lon string `json:"lon,omitempty"`
+	//+optional
+	Lat string `json:"lat,omitempty"`
+	// Location is geocoded into coordinates by the provider, as an alternative to lat and lon
+	//+optional
+	Location *LocationSpec `json:"location,omitempty"`
+	// SecretRef holds the provider API token; optional for providers that need no token
+	//+optional
+	SecretRef *SecretRefSpec `json:"secretRef,omitempty"`
+	// RefreshPeriod is how often the weather is fetched, as a Go duration (defaults to 5m)
+	//+optional
+	RefreshPeriod string `json:"refreshPeriod,omitempty"`
+	// Provider is the upstream weather service used to fetch current conditions
+	//+kubebuilder:validation:Enum=openweathermap;nws;openmeteo
+	//+kubebuilder:default=openweathermap
+	//+optional
+	Provider string `json:"provider,omitempty"`
+	// Providers is an ordered failover chain used instead of provider and secretRef: when a provider is
+	// unavailable or rate limited, the next one is queried
+	//+kubebuilder:validation:MaxItems=5
+	//+listType=map
+	//+listMapKey=name
+	//+optional
+	Providers []ProviderRef `json:"providers,omitempty"`
+	// Units is the unit system measurements are reported in: imperial (F, mph), metric (C, m/s) or standard (K, m/s)
+	//+kubebuilder:validation:Enum=imperial;metric;standard
+	//+kubebuilder:default=imperial
+	//+optional
+	Units string `json:"units,omitempty"`
+	// Forecast, when set, also fetches a forecast into status.forecast
+	//+optional
+	Forecast *ForecastSpec `json:"forecast,omitempty"`
+	// History, when set, keeps the most recent observations in status.history
+	//+optional
+	History *HistorySpec `json:"history,omitempty"`
+	// Alerts, when true, also fetches the active severe weather alerts for the location into status.alerts.
+	// openweathermap alerts need a One Call API subscription; openmeteo has no alerts.
+	//+optional
+	Alerts bool `json:"alerts,omitempty"`
+	// AirQuality, when true, also fetches the air pollution for the location into status.airQuality.
+	// Only openweathermap reports air quality.
+	//+optional
+	AirQuality bool `json:"airQuality,omitempty"`
+	// Thresholds are evaluated after each refresh, reporting breaches in the ThresholdBreached condition
+	// and Warning events
+	//+kubebuilder:validation:MaxItems=20
+	//+listType=map
+	//+listMapKey=name
+	//+optional
+	Thresholds []Threshold `json:"thresholds,omitempty"`
+	// Notify are webhook targets notified when the measurements change
+	//+kubebuilder:validation:MaxItems=10
+	//+listType=map
+	//+listMapKey=name
+	//+optional
+	Notify []NotifyTarget `json:"notify,omitempty"`
+}
+
+// Value is a float64, which needs controller-gen's crd:allowDangerousTypes (see CRD_OPTIONS in the Makefile).
+
+// Measurement is a numeric reading together with the unit it is expressed in
+type Measurement struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+// ForecastPeriod is the forecast for the period starting at Time
+type ForecastPeriod struct {
+	Time metav1.Time  `json:"time"`
+	Temp *Measurement `json:"temp,omitempty"`
+	// PrecipitationProbability is the probability of precipitation during the period, in percent
+	PrecipitationProbability *Measurement `json:"precipitationProbability,omitempty"`
+	// Summary is the provider's short description of the conditions, e.g. "light rain"
+	Summary string `json:"summary,omitempty"`
+}
+
+// WeatherCondition is the provider's summary of the current conditions
+type WeatherCondition struct {
+	// Id is the OpenWeatherMap condition code, e.g. 500 for light rain
+	//+optional
+	Id int32 `json:"id,omitempty"`
+	// Main is the group of conditions, e.g. "Rain"
+	Main string `json:"main"`
+	// Description details the conditions within the group, e.g. "light rain"
+	//+optional
+	Description string `json:"description,omitempty"`
+	// Icon is the OpenWeatherMap icon id, e.g. "10d"
+	//+optional
+	Icon string `json:"icon,omitempty"`
+}
+
+// WindDirection is the direction the wind blows from
+type WindDirection struct {
+	// Degrees is the meteorological wind direction, clockwise from north
+	Degrees int32 `json:"degrees"`
+	// Cardinal is the 16-point compass direction, e.g. "WSW"
+	Cardinal string `json:"cardinal"`
+}
+
+// Precipitation is the volume of rain or snow that fell recently
+type Precipitation struct {
+	// LastHour is the volume of the last hour
+	//+optional
+	LastHour *Measurement `json:"lastHour,omitempty"`
+	// Last3Hours is the volume of the last 3 hours
+	//+optional
+	Last3Hours *Measurement `json:"last3Hours,omitempty"`
+}
+
+// HistorySample is a past observation of the measurements
+type HistorySample struct {
+	// Time is when the provider observed the measurements
+	Time      metav1.Time  `json:"time"`
+	Temp      *Measurement `json:"temp,omitempty"`
+	Pressure  *Measurement `json:"pressure,omitempty"`
+	Humidity  *Measurement `json:"humidity,omitempty"`
+	WindSpeed *Measurement `json:"windSpeed,omitempty"`
+	WindGust  *Measurement `json:"windGust,omitempty"`
+}
+
+// WeatherAlert is an active severe weather alert issued for the location
+type WeatherAlert struct {
+	// Id identifies the alert across refreshes
+	Id string `json:"id"`
+	// Event is the kind of alert, e.g. "Severe Thunderstorm Warning"
+	Event    string `json:"event"`
+	Headline string `json:"headline,omitempty"`
+	// Severity is Extreme, Severe, Moderate, Minor or Unknown
+	Severity string       `json:"severity,omitempty"`
+	Sender   string       `json:"sender,omitempty"`
+	Start    *metav1.Time `json:"start,omitempty"`
+	End      *metav1.Time `json:"end,omitempty"`
+}
+
+// AirQuality is the air pollution observed at the location
+type AirQuality struct {
+	// Time is when the air pollution was observed
+	Time metav1.Time `json:"time"`
+	// Index is the air quality index, from 1 (good) to 5 (very poor)
+	Index int32 `json:"index"`
+	// Category names the index: Good, Fair, Moderate, Poor or Very Poor
+	Category string `json:"category,omitempty"`
+	// CO, NO2, O3, SO2, PM25 and PM10 are pollutant concentrations
+	CO   *Measurement `json:"co,omitempty"`
+	NO2  *Measurement `json:"no2,omitempty"`
+	O3   *Measurement `json:"o3,omitempty"`
+	SO2  *Measurement `json:"so2,omitempty"`
+	PM25 *Measurement `json:"pm2_5,omitempty"`
+	PM10 *Measurement `json:"pm10,omitempty"`
+}
+
+// NotificationStatus is the outcome of the latest delivery to a spec.notify target
+type NotificationStatus struct {
+	Name string `json:"name"`
+	// LastAttemptTime is when the latest delivery was attempted
+	LastAttemptTime *metav1.Time `json:"lastAttemptTime,omitempty"`
+	// LastSuccessTime is when a delivery last succeeded
+	LastSuccessTime *metav1.Time `json:"lastSuccessTime,omitempty"`
+	// Attempts is how many requests the latest delivery took
+	Attempts int32 `json:"attempts,omitempty"`
+	// StatusCode is the HTTP status of the latest request, 0 when no response was received
+	StatusCode int32 `json:"statusCode,omitempty"`
+	// Error describes why the latest delivery failed, empty when it succeeded
+	Error string `json:"error,omitempty"`
+}
+
+// ResolvedCoordinates are the coordinates spec.location was geocoded into
+type ResolvedCoordinates struct {
+	Lat string `json:"lat"`
+	Lon string `json:"lon"`
+	// Name is the place the geocoder matched
+	Name string `json:"name,omitempty"`
+	// Location is the spec.location the coordinates were resolved from; they are resolved again when it changes
+	Location LocationSpec `json:"location"`
+}
+
+// WeatherStatus defines the observed state of Weather
+type WeatherStatus struct {
+	// RefreshTime is when the provider observed the current conditions
+	RefreshTime *metav1.Time `json:"refreshTime,omitempty"`
+	// LastFetchTime is when the operator last fetched the conditions; the next fetch is due refreshPeriod later
+	//+optional
+	LastFetchTime *metav1.Time `json:"lastFetchTime,omitempty"`
+	CountryCode   string       `json:"countryCode,omitempty"`
+	LocationName  string       `json:"locationName,omitempty"`
+	// Units is the unit system the measurements were requested in
+	Units     string       `json:"units,omitempty"`
+	Temp      *Measurement `json:"temp,omitempty"`
+	Pressure  *Measurement `json:"pressure,omitempty"`
+	Humidity  *Measurement `json:"humidity,omitempty"`
+	WindSpeed *Measurement `json:"windSpeed,omitempty"`
+	WindGust  *Measurement `json:"windGust,omitempty"`
+	// The following are only reported by some providers (all of them by openweathermap)
+	//+optional
+	FeelsLike *Measurement `json:"feelsLike,omitempty"`
+	// TempMin and TempMax are the range of temperatures currently observed across the area
+	//+optional
+	TempMin *Measurement `json:"tempMin,omitempty"`
+	//+optional
+	TempMax *Measurement `json:"tempMax,omitempty"`
+	//+optional
+	Visibility *Measurement `json:"visibility,omitempty"`
+	//+optional
+	WindDirection *WindDirection `json:"windDirection,omitempty"`
+	//+optional
+	CloudCover *Measurement `json:"cloudCover,omitempty"`
+	//+optional
+	Condition *WeatherCondition `json:"condition,omitempty"`
+	//+optional
+	Sunrise *metav1.Time `json:"sunrise,omitempty"`
+	//+optional
+	Sunset *metav1.Time `json:"sunset,omitempty"`
+	// Rain and Snow are only reported when there was precipitation recently
+	//+optional
+	Rain *Precipitation `json:"rain,omitempty"`
+	//+optional
+	Snow *Precipitation `json:"snow,omitempty"`
+	// Provider is the provider the current weather data was fetched from
+	//+optional
+	Provider string `json:"provider,omitempty"`
+	// SecretResourceVersion holds the resourceVersions of the secrets the API tokens were last read from. A
+	// weather whose token was rejected is not refreshed again until a secret changes.
+	//+optional
+	SecretResourceVersion string `json:"secretResourceVersion,omitempty"`
+	// ResolvedCoordinates are the coordinates of spec.location
+	//+optional
+	ResolvedCoordinates *ResolvedCoordinates `json:"resolvedCoordinates,omitempty"`
+	// Forecast holds the upcoming forecast periods, in order, when spec.forecast is set
+	//+kubebuilder:validation:MaxItems=40
+	//+optional
+	Forecast []ForecastPeriod `json:"forecast,omitempty"`
+	// History holds the most recent observations, oldest first, when spec.history is set
+	//+kubebuilder:validation:MaxItems=100
+	//+optional
+	History []HistorySample `json:"history,omitempty"`
+	// BreachedThresholds are the names of the spec.thresholds currently breached
+	//+optional
+	BreachedThresholds []string `json:"breachedThresholds,omitempty"`
+	// Notifications record the latest delivery to each spec.notify target
+	//+listType=map
+	//+listMapKey=name
+	//+optional
+	Notifications []NotificationStatus `json:"notifications,omitempty"`
+	// Alerts are the active severe weather alerts, when spec.alerts is set
+	//+kubebuilder:validation:MaxItems=20
+	//+optional
+	Alerts []WeatherAlert `json:"alerts,omitempty"`
+	// AirQuality is the air pollution at the location, when spec.airQuality is set
+	//+optional
+	AirQuality *AirQuality `json:"airQuality,omitempty"`
+	// ObservedGeneration is the most recent spec generation the status was computed from
+	//+optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions describe the outcome of the latest refresh (Ready, SecretResolved, ProviderReachable, Stale)
+	//+optional
+	//+patchMergeKey=type
+	//+patchStrategy=merge
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:printcolumn:name="Lat",type="string",JSONPath=".spec.lat",description="Latitude"
+//+kubebuilder:printcolumn:name="Lon",type="string",JSONPath=".spec.lon",description="Longitude"
+//+kubebuilder:printcolumn:name="Location",type="string",JSONPath=".status.locationName",description="Location"
+//+kubebuilder:printcolumn:name="Temp",type="number",JSONPath=".status.temp.value",description="Temp"
+//+kubebuilder:printcolumn:name="Unit",type="string",JSONPath=".status.temp.unit",description="Temperature unit"
+//+kubebuilder:printcolumn:name="Humidity",type="number",JSONPath=".status.humidity.value",description="Relative humidity (%)"
+//+kubebuilder:printcolumn:name="Condition",type="string",JSONPath=".status.condition.main",description="Condition"
+//+kubebuilder:printcolumn:name="Next Temp",type="number",JSONPath=".status.forecast[0].temp.value",description="Temp forecast for the next period"
+//+kubebuilder:printcolumn:name="Precip",type="number",JSONPath=".status.forecast[0].precipitationProbability.value",description="Precipitation probability (%) for the next period"
+//+kubebuilder:printcolumn:name="Provider",type="string",JSONPath=".status.provider",description="Provider the data is from",priority=1
+//+kubebuilder:printcolumn:name="Refreshed",type="date",JSONPath=".status.refreshTime",description="Refreshed"
+//+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description="Ready"
+
+// Weather is the Schema for the weathers API
+type Weather struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WeatherSpec   `json:"spec,omitempty"`
+	Status WeatherStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// WeatherList contains a list of Weather
+type WeatherList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Weather `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Weather{}, &WeatherList{})
+}
+
+// ProviderRequiresToken reports whether a provider needs an API token from spec.secretRef
+func ProviderRequiresToken(provider string) bool {
+	return provider == ProviderOpenWeatherMap
+}
+
+// ProviderChain returns the providers to query in order: spec.providers, or else spec.provider with spec.secretRef
+func (s *WeatherSpec) ProviderChain() []ProviderRef {
+	if len(s.Providers) > 0 {
+		return s.Providers
+	}
+	provider := s.Provider
+	if len(provider) == 0 {
+		provider = DefaultProvider
+	}
+	return []ProviderRef{{Name: provider, SecretRef: s.SecretRef}}
+}
+
+// ProviderSupportsGeocoding reports whether a provider can resolve spec.location into coordinates
+func ProviderSupportsGeocoding(provider string) bool {
+	return provider == ProviderOpenWeatherMap || provider == ProviderOpenMeteo
+}
+
+// TemperatureUnit returns the unit temperatures are reported in for a unit system
+func TemperatureUnit(units string) string {
+	switch units {
+	case UnitsMetric:
+		return UnitCelsius
+	case UnitsStandard:
+		return UnitKelvin
+	default:
+		return UnitFahrenheit
+	}
+}
+
+// SpeedUnit returns the unit wind speeds are reported in for a unit system
+func SpeedUnit(units string) string {
+	switch units {
+	case UnitsMetric, UnitsStandard:
+		return UnitMetersPerSecond
+	default:
+		return UnitMilesPerHour
+	}
+}
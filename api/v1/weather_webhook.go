@@ -0,0 +1,182 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var weatherlog = logf.Log.WithName("weather-resource")
+
+// SetupWebhookWithManager registers the Weather webhooks, including the /convert endpoint, with the manager
+func (r *Weather) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-weather-alsup-v1-weather,mutating=true,failurePolicy=fail,sideEffects=None,groups=weather.alsup,resources=weathers,verbs=create;update,versions=v1,name=mweather.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &Weather{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *Weather) Default() {
+	weatherlog.Info("default", "name", r.Name)
+
+	if len(r.Spec.RefreshPeriod) == 0 {
+		r.Spec.RefreshPeriod = DefaultRefreshPeriod
+	}
+	if len(r.Spec.Provider) == 0 {
+		r.Spec.Provider = DefaultProvider
+	}
+	if len(r.Spec.Units) == 0 {
+		r.Spec.Units = DefaultUnits
+	}
+	if r.Spec.SecretRef != nil && len(r.Spec.SecretRef.Key) == 0 {
+		r.Spec.SecretRef.Key = DefaultSecretKey
+	}
+	for i := range r.Spec.Providers {
+		if secretRef := r.Spec.Providers[i].SecretRef; secretRef != nil && len(secretRef.Key) == 0 {
+			secretRef.Key = DefaultSecretKey
+		}
+	}
+	if r.Spec.Forecast != nil && r.Spec.Forecast.Periods == 0 {
+		r.Spec.Forecast.Periods = DefaultForecastPeriods
+	}
+	if r.Spec.History != nil && r.Spec.History.Samples == 0 {
+		r.Spec.History.Samples = DefaultHistorySamples
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-weather-alsup-v1-weather,mutating=false,failurePolicy=fail,sideEffects=None,groups=weather.alsup,resources=weathers,verbs=create;update,versions=v1,name=vweather.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Weather{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Weather) ValidateCreate() error {
+	weatherlog.Info("validate create", "name", r.Name)
+	return r.validateWeather()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Weather) ValidateUpdate(old runtime.Object) error {
+	weatherlog.Info("validate update", "name", r.Name)
+	return r.validateWeather()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Weather) ValidateDelete() error {
+	return nil
+}
+
+func (r *Weather) validateWeather() error {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	chain := r.Spec.ProviderChain()
+
+	if r.Spec.Location == nil {
+		allErrs = append(allErrs, validateCoordinate(specPath.Child("lat"), r.Spec.Lat, 90)...)
+		allErrs = append(allErrs, validateCoordinate(specPath.Child("lon"), r.Spec.Lon, 180)...)
+	} else {
+		locationPath := specPath.Child("location")
+		if len(r.Spec.Lat) > 0 || len(r.Spec.Lon) > 0 {
+			allErrs = append(allErrs, field.Forbidden(locationPath, "must not be set together with lat and lon"))
+		}
+		if len(r.Spec.Location.City) == 0 && len(r.Spec.Location.Zip) == 0 {
+			allErrs = append(allErrs, field.Required(locationPath, "must set a city or a zip"))
+		}
+		for _, provider := range chain {
+			if !ProviderSupportsGeocoding(provider.Name) {
+				allErrs = append(allErrs, field.Invalid(locationPath, *r.Spec.Location,
+					fmt.Sprintf("provider '%s' cannot geocode locations, set lat and lon instead", provider.Name)))
+			}
+		}
+	}
+
+	if len(r.Spec.RefreshPeriod) > 0 {
+		refreshPath := specPath.Child("refreshPeriod")
+		refreshPeriod, err := time.ParseDuration(r.Spec.RefreshPeriod)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(refreshPath, r.Spec.RefreshPeriod, "must be a duration such as '5m'"))
+		} else if refreshPeriod < MinRefreshPeriod {
+			allErrs = append(allErrs, field.Invalid(refreshPath, r.Spec.RefreshPeriod,
+				fmt.Sprintf("must be at least %s", MinRefreshPeriod)))
+		}
+	}
+
+	if len(r.Spec.Providers) == 0 {
+		allErrs = append(allErrs, validateSecretRef(specPath.Child("secretRef"), chain[0])...)
+	} else {
+		if r.Spec.SecretRef != nil {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("secretRef"),
+				"must not be set together with providers, set providers[].secretRef instead"))
+		}
+		for i, provider := range r.Spec.Providers {
+			allErrs = append(allErrs, validateSecretRef(specPath.Child("providers").Index(i).Child("secretRef"), provider)...)
+		}
+	}
+
+	for i, threshold := range r.Spec.Thresholds {
+		if threshold.Hysteresis < 0 {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("thresholds").Index(i).Child("hysteresis"),
+				threshold.Hysteresis, "must not be negative"))
+		}
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Weather").GroupKind(), r.Name, allErrs)
+}
+
+// validateSecretRef checks that a provider needing an API token references the secret holding it
+func validateSecretRef(path *field.Path, provider ProviderRef) field.ErrorList {
+	if provider.SecretRef == nil {
+		if ProviderRequiresToken(provider.Name) {
+			return field.ErrorList{field.Required(path, fmt.Sprintf("provider '%s' requires an API token secret", provider.Name))}
+		}
+		return nil
+	}
+	if len(provider.SecretRef.Name) == 0 {
+		return field.ErrorList{field.Required(path.Child("name"), "must name the API token secret")}
+	}
+	return nil
+}
+
+// validateCoordinate checks that a lat/lon string is a number within [-limit, limit]
+func validateCoordinate(path *field.Path, value string, limit float64) field.ErrorList {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) {
+		return field.ErrorList{field.Invalid(path, value, "must be a decimal number")}
+	}
+	if v < -limit || v > limit {
+		return field.ErrorList{field.Invalid(path, value, fmt.Sprintf("must be between %g and %g", -limit, limit))}
+	}
+	return nil
+}
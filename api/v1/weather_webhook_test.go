@@ -0,0 +1,138 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Weather webhook", func() {
+	var weather *Weather
+
+	BeforeEach(func() {
+		weather = &Weather{
+			ObjectMeta: metav1.ObjectMeta{Name: "sample", Namespace: "default"},
+			Spec: WeatherSpec{
+				Lat:       "38.446507669062406",
+				Lon:       "-77.98832108933742",
+				SecretRef: &SecretRefSpec{Name: "weather-api-secret"},
+			},
+		}
+	})
+
+	It("defaults the refresh period, provider, units and secret key", func() {
+		weather.Default()
+		Expect(weather.Spec.RefreshPeriod).To(Equal(DefaultRefreshPeriod))
+		Expect(weather.Spec.Provider).To(Equal(ProviderOpenWeatherMap))
+		Expect(weather.Spec.Units).To(Equal(UnitsImperial))
+		Expect(weather.Spec.SecretRef.Key).To(Equal("token"))
+	})
+
+	It("defaults the forecast periods and history samples", func() {
+		weather.Spec.Forecast = &ForecastSpec{}
+		weather.Spec.History = &HistorySpec{}
+		weather.Default()
+		Expect(weather.Spec.Forecast.Periods).To(Equal(DefaultForecastPeriods))
+		Expect(weather.Spec.History.Samples).To(Equal(DefaultHistorySamples))
+	})
+
+	It("does not override values that are set", func() {
+		weather.Spec.RefreshPeriod = "10m"
+		weather.Spec.Units = UnitsMetric
+		weather.Default()
+		Expect(weather.Spec.RefreshPeriod).To(Equal("10m"))
+		Expect(weather.Spec.Units).To(Equal(UnitsMetric))
+	})
+
+	It("accepts a valid weather", func() {
+		weather.Default()
+		Expect(weather.ValidateCreate()).To(Succeed())
+		Expect(weather.ValidateUpdate(weather.DeepCopy())).To(Succeed())
+	})
+
+	DescribeTable("rejects an invalid spec",
+		func(mutate func(*Weather), field string) {
+			weather.Default()
+			mutate(weather)
+			err := weather.ValidateCreate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(field))
+		},
+		Entry("latitude out of range", func(w *Weather) { w.Spec.Lat = "91" }, "spec.lat"),
+		Entry("non-numeric latitude", func(w *Weather) { w.Spec.Lat = "north" }, "spec.lat"),
+		Entry("NaN latitude", func(w *Weather) { w.Spec.Lat = "NaN" }, "spec.lat"),
+		Entry("NaN longitude", func(w *Weather) { w.Spec.Lon = "nan" }, "spec.lon"),
+		Entry("longitude out of range", func(w *Weather) { w.Spec.Lon = "-180.5" }, "spec.lon"),
+		Entry("unparsable refresh period", func(w *Weather) { w.Spec.RefreshPeriod = "5 minutes" }, "spec.refreshPeriod"),
+		Entry("too short refresh period", func(w *Weather) { w.Spec.RefreshPeriod = "10s" }, "spec.refreshPeriod"),
+		Entry("missing secret ref", func(w *Weather) { w.Spec.SecretRef = nil }, "spec.secretRef"),
+		Entry("unnamed secret ref", func(w *Weather) { w.Spec.SecretRef.Name = "" }, "spec.secretRef.name"),
+		Entry("location without city or zip", func(w *Weather) {
+			w.Spec.Lat, w.Spec.Lon = "", ""
+			w.Spec.Location = &LocationSpec{CountryCode: "US"}
+		}, "spec.location"),
+		Entry("location with coordinates", func(w *Weather) { w.Spec.Location = &LocationSpec{City: "Culpeper"} }, "spec.location"),
+		Entry("location with a provider that cannot geocode", func(w *Weather) {
+			w.Spec.Lat, w.Spec.Lon = "", ""
+			w.Spec.Location = &LocationSpec{City: "Culpeper"}
+			w.Spec.Provider = ProviderNWS
+		}, "spec.location"),
+		Entry("secret ref together with providers", func(w *Weather) {
+			w.Spec.Providers = []ProviderRef{{Name: ProviderNWS}}
+		}, "spec.secretRef"),
+		Entry("provider chain member without its secret ref", func(w *Weather) {
+			w.Spec.SecretRef = nil
+			w.Spec.Providers = []ProviderRef{{Name: ProviderNWS}, {Name: ProviderOpenWeatherMap}}
+		}, "spec.providers[1].secretRef"),
+		Entry("location with a provider chain member that cannot geocode", func(w *Weather) {
+			w.Spec.Lat, w.Spec.Lon, w.Spec.SecretRef = "", "", nil
+			w.Spec.Location = &LocationSpec{City: "Culpeper"}
+			w.Spec.Providers = []ProviderRef{{Name: ProviderOpenMeteo}, {Name: ProviderNWS}}
+		}, "spec.location"),
+		Entry("negative threshold hysteresis", func(w *Weather) {
+			w.Spec.Thresholds = []Threshold{{Name: "heat", Measurement: MeasurementTemp, Operator: ThresholdAbove, Value: 95, Hysteresis: -1}}
+		}, "spec.thresholds[0].hysteresis"),
+	)
+
+	It("accepts a location instead of coordinates", func() {
+		weather.Spec.Lat, weather.Spec.Lon = "", ""
+		weather.Spec.Location = &LocationSpec{Zip: "22701", CountryCode: "US"}
+		weather.Default()
+		Expect(weather.ValidateCreate()).To(Succeed())
+	})
+
+	It("does not require a secret ref for tokenless providers", func() {
+		weather.Spec.Provider = ProviderNWS
+		weather.Spec.SecretRef = nil
+		weather.Default()
+		Expect(weather.ValidateCreate()).To(Succeed())
+	})
+
+	It("accepts a provider chain and defaults its secret keys", func() {
+		weather.Spec.SecretRef = nil
+		weather.Spec.Providers = []ProviderRef{
+			{Name: ProviderOpenWeatherMap, SecretRef: &SecretRefSpec{Name: "weather-api-secret"}},
+			{Name: ProviderOpenMeteo},
+		}
+		weather.Default()
+		Expect(weather.Spec.Providers[0].SecretRef.Key).To(Equal("token"))
+		Expect(weather.ValidateCreate()).To(Succeed())
+	})
+})
@@ -0,0 +1,667 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AirQuality) DeepCopyInto(out *AirQuality) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+	if in.CO != nil {
+		in, out := &in.CO, &out.CO
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.NO2 != nil {
+		in, out := &in.NO2, &out.NO2
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.O3 != nil {
+		in, out := &in.O3, &out.O3
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.SO2 != nil {
+		in, out := &in.SO2, &out.SO2
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.PM25 != nil {
+		in, out := &in.PM25, &out.PM25
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.PM10 != nil {
+		in, out := &in.PM10, &out.PM10
+		*out = new(Measurement)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AirQuality.
+func (in *AirQuality) DeepCopy() *AirQuality {
+	if in == nil {
+		return nil
+	}
+	out := new(AirQuality)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ForecastPeriod) DeepCopyInto(out *ForecastPeriod) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+	if in.Temp != nil {
+		in, out := &in.Temp, &out.Temp
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.PrecipitationProbability != nil {
+		in, out := &in.PrecipitationProbability, &out.PrecipitationProbability
+		*out = new(Measurement)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ForecastPeriod.
+func (in *ForecastPeriod) DeepCopy() *ForecastPeriod {
+	if in == nil {
+		return nil
+	}
+	out := new(ForecastPeriod)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ForecastSpec) DeepCopyInto(out *ForecastSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ForecastSpec.
+func (in *ForecastSpec) DeepCopy() *ForecastSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ForecastSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HistorySample) DeepCopyInto(out *HistorySample) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+	if in.Temp != nil {
+		in, out := &in.Temp, &out.Temp
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.Pressure != nil {
+		in, out := &in.Pressure, &out.Pressure
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.Humidity != nil {
+		in, out := &in.Humidity, &out.Humidity
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.WindSpeed != nil {
+		in, out := &in.WindSpeed, &out.WindSpeed
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.WindGust != nil {
+		in, out := &in.WindGust, &out.WindGust
+		*out = new(Measurement)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HistorySample.
+func (in *HistorySample) DeepCopy() *HistorySample {
+	if in == nil {
+		return nil
+	}
+	out := new(HistorySample)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HistorySpec) DeepCopyInto(out *HistorySpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HistorySpec.
+func (in *HistorySpec) DeepCopy() *HistorySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HistorySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LocationSpec) DeepCopyInto(out *LocationSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LocationSpec.
+func (in *LocationSpec) DeepCopy() *LocationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(LocationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Measurement) DeepCopyInto(out *Measurement) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Measurement.
+func (in *Measurement) DeepCopy() *Measurement {
+	if in == nil {
+		return nil
+	}
+	out := new(Measurement)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NotificationStatus) DeepCopyInto(out *NotificationStatus) {
+	*out = *in
+	if in.LastAttemptTime != nil {
+		in, out := &in.LastAttemptTime, &out.LastAttemptTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastSuccessTime != nil {
+		in, out := &in.LastSuccessTime, &out.LastSuccessTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NotificationStatus.
+func (in *NotificationStatus) DeepCopy() *NotificationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NotificationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NotifyTarget) DeepCopyInto(out *NotifyTarget) {
+	*out = *in
+	out.URLSecretRef = in.URLSecretRef
+	if in.HMACSecretRef != nil {
+		in, out := &in.HMACSecretRef, &out.HMACSecretRef
+		*out = new(SecretKeyRef)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NotifyTarget.
+func (in *NotifyTarget) DeepCopy() *NotifyTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(NotifyTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Precipitation) DeepCopyInto(out *Precipitation) {
+	*out = *in
+	if in.LastHour != nil {
+		in, out := &in.LastHour, &out.LastHour
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.Last3Hours != nil {
+		in, out := &in.Last3Hours, &out.Last3Hours
+		*out = new(Measurement)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Precipitation.
+func (in *Precipitation) DeepCopy() *Precipitation {
+	if in == nil {
+		return nil
+	}
+	out := new(Precipitation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProviderRef) DeepCopyInto(out *ProviderRef) {
+	*out = *in
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(SecretRefSpec)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProviderRef.
+func (in *ProviderRef) DeepCopy() *ProviderRef {
+	if in == nil {
+		return nil
+	}
+	out := new(ProviderRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResolvedCoordinates) DeepCopyInto(out *ResolvedCoordinates) {
+	*out = *in
+	out.Location = in.Location
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResolvedCoordinates.
+func (in *ResolvedCoordinates) DeepCopy() *ResolvedCoordinates {
+	if in == nil {
+		return nil
+	}
+	out := new(ResolvedCoordinates)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretKeyRef) DeepCopyInto(out *SecretKeyRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretKeyRef.
+func (in *SecretKeyRef) DeepCopy() *SecretKeyRef {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretKeyRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretRefSpec) DeepCopyInto(out *SecretRefSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretRefSpec.
+func (in *SecretRefSpec) DeepCopy() *SecretRefSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretRefSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Threshold) DeepCopyInto(out *Threshold) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Threshold.
+func (in *Threshold) DeepCopy() *Threshold {
+	if in == nil {
+		return nil
+	}
+	out := new(Threshold)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Weather) DeepCopyInto(out *Weather) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Weather.
+func (in *Weather) DeepCopy() *Weather {
+	if in == nil {
+		return nil
+	}
+	out := new(Weather)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Weather) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WeatherAlert) DeepCopyInto(out *WeatherAlert) {
+	*out = *in
+	if in.Start != nil {
+		in, out := &in.Start, &out.Start
+		*out = (*in).DeepCopy()
+	}
+	if in.End != nil {
+		in, out := &in.End, &out.End
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WeatherAlert.
+func (in *WeatherAlert) DeepCopy() *WeatherAlert {
+	if in == nil {
+		return nil
+	}
+	out := new(WeatherAlert)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WeatherCondition) DeepCopyInto(out *WeatherCondition) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WeatherCondition.
+func (in *WeatherCondition) DeepCopy() *WeatherCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(WeatherCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WeatherList) DeepCopyInto(out *WeatherList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Weather, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WeatherList.
+func (in *WeatherList) DeepCopy() *WeatherList {
+	if in == nil {
+		return nil
+	}
+	out := new(WeatherList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WeatherList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WeatherSpec) DeepCopyInto(out *WeatherSpec) {
+	*out = *in
+	if in.Location != nil {
+		in, out := &in.Location, &out.Location
+		*out = new(LocationSpec)
+		**out = **in
+	}
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(SecretRefSpec)
+		**out = **in
+	}
+	if in.Providers != nil {
+		in, out := &in.Providers, &out.Providers
+		*out = make([]ProviderRef, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Forecast != nil {
+		in, out := &in.Forecast, &out.Forecast
+		*out = new(ForecastSpec)
+		**out = **in
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = new(HistorySpec)
+		**out = **in
+	}
+	if in.Thresholds != nil {
+		in, out := &in.Thresholds, &out.Thresholds
+		*out = make([]Threshold, len(*in))
+		copy(*out, *in)
+	}
+	if in.Notify != nil {
+		in, out := &in.Notify, &out.Notify
+		*out = make([]NotifyTarget, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WeatherSpec.
+func (in *WeatherSpec) DeepCopy() *WeatherSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WeatherSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WeatherStatus) DeepCopyInto(out *WeatherStatus) {
+	*out = *in
+	if in.RefreshTime != nil {
+		in, out := &in.RefreshTime, &out.RefreshTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastFetchTime != nil {
+		in, out := &in.LastFetchTime, &out.LastFetchTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Temp != nil {
+		in, out := &in.Temp, &out.Temp
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.Pressure != nil {
+		in, out := &in.Pressure, &out.Pressure
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.Humidity != nil {
+		in, out := &in.Humidity, &out.Humidity
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.WindSpeed != nil {
+		in, out := &in.WindSpeed, &out.WindSpeed
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.WindGust != nil {
+		in, out := &in.WindGust, &out.WindGust
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.FeelsLike != nil {
+		in, out := &in.FeelsLike, &out.FeelsLike
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.TempMin != nil {
+		in, out := &in.TempMin, &out.TempMin
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.TempMax != nil {
+		in, out := &in.TempMax, &out.TempMax
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.Visibility != nil {
+		in, out := &in.Visibility, &out.Visibility
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.WindDirection != nil {
+		in, out := &in.WindDirection, &out.WindDirection
+		*out = new(WindDirection)
+		**out = **in
+	}
+	if in.CloudCover != nil {
+		in, out := &in.CloudCover, &out.CloudCover
+		*out = new(Measurement)
+		**out = **in
+	}
+	if in.Condition != nil {
+		in, out := &in.Condition, &out.Condition
+		*out = new(WeatherCondition)
+		**out = **in
+	}
+	if in.Sunrise != nil {
+		in, out := &in.Sunrise, &out.Sunrise
+		*out = (*in).DeepCopy()
+	}
+	if in.Sunset != nil {
+		in, out := &in.Sunset, &out.Sunset
+		*out = (*in).DeepCopy()
+	}
+	if in.Rain != nil {
+		in, out := &in.Rain, &out.Rain
+		*out = new(Precipitation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Snow != nil {
+		in, out := &in.Snow, &out.Snow
+		*out = new(Precipitation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ResolvedCoordinates != nil {
+		in, out := &in.ResolvedCoordinates, &out.ResolvedCoordinates
+		*out = new(ResolvedCoordinates)
+		**out = **in
+	}
+	if in.Forecast != nil {
+		in, out := &in.Forecast, &out.Forecast
+		*out = make([]ForecastPeriod, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]HistorySample, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.BreachedThresholds != nil {
+		in, out := &in.BreachedThresholds, &out.BreachedThresholds
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Notifications != nil {
+		in, out := &in.Notifications, &out.Notifications
+		*out = make([]NotificationStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Alerts != nil {
+		in, out := &in.Alerts, &out.Alerts
+		*out = make([]WeatherAlert, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.AirQuality != nil {
+		in, out := &in.AirQuality, &out.AirQuality
+		*out = new(AirQuality)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WeatherStatus.
+func (in *WeatherStatus) DeepCopy() *WeatherStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WeatherStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WindDirection) DeepCopyInto(out *WindDirection) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WindDirection.
+func (in *WindDirection) DeepCopy() *WindDirection {
+	if in == nil {
+		return nil
+	}
+	out := new(WindDirection)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	weatherv1 "alsup/api/v1"
+)
+
+// refreshTimeLayout is the time.Time.String() layout status.refresh_time is written in
+const refreshTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// conversionDataAnnotation holds the v1 spec and status of a Weather served as v1beta1, so that the v1 fields
+// v1beta1 cannot represent survive a v1beta1 update
+const conversionDataAnnotation = "weather.alsup/v1-conversion-data"
+
+// conversionData is the content of the conversionDataAnnotation
+type conversionData struct {
+	Spec   weatherv1.WeatherSpec   `json:"spec"`
+	Status weatherv1.WeatherStatus `json:"status"`
+}
+
+// ConvertTo converts this Weather to the Hub version (v1). The v1 spec and status saved by ConvertFrom are
+// restored, with the fields changed in v1beta1 applied on top.
+func (src *Weather) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*weatherv1.Weather)
+	src.convertTo(dst)
+	data, ok := src.Annotations[conversionDataAnnotation]
+	if !ok {
+		return nil
+	}
+	dst.Annotations = withoutAnnotation(src.Annotations, conversionDataAnnotation)
+	var saved conversionData
+	if err := json.Unmarshal([]byte(data), &saved); err != nil {
+		return fmt.Errorf("cannot restore v1 fields from annotation %s: %w", conversionDataAnnotation, err)
+	}
+	// shown is the v1beta1 view of the saved fields, telling which fields were changed in v1beta1
+	shown := &Weather{}
+	shown.convertFrom(&weatherv1.Weather{Spec: saved.Spec, Status: saved.Status})
+
+	spec := saved.Spec
+	spec.RefreshPeriod = dst.Spec.RefreshPeriod
+	spec.Units = dst.Spec.Units
+	if src.Spec.Lat != shown.Spec.Lat || src.Spec.Lon != shown.Spec.Lon {
+		spec.Lat, spec.Lon, spec.Location = dst.Spec.Lat, dst.Spec.Lon, nil
+	}
+	if src.Spec.Provider != shown.Spec.Provider || !reflect.DeepEqual(src.Spec.SecretRef, shown.Spec.SecretRef) {
+		spec.Provider, spec.SecretRef, spec.Providers = dst.Spec.Provider, dst.Spec.SecretRef, nil
+	}
+	dst.Spec = spec
+
+	status := saved.Status
+	if src.Status != shown.Status {
+		status.CountryCode = dst.Status.CountryCode
+		status.LocationName = dst.Status.LocationName
+		status.Units = dst.Status.Units
+		status.RefreshTime = dst.Status.RefreshTime
+		status.Temp = dst.Status.Temp
+		status.Pressure = dst.Status.Pressure
+		status.Humidity = dst.Status.Humidity
+		status.WindSpeed = dst.Status.WindSpeed
+		status.WindGust = dst.Status.WindGust
+	}
+	dst.Status = status
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1) to this version, saving the v1 spec and status in the
+// conversionDataAnnotation
+func (dst *Weather) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*weatherv1.Weather)
+	dst.convertFrom(src)
+	data, err := json.Marshal(conversionData{Spec: src.Spec, Status: src.Status})
+	if err != nil {
+		return err
+	}
+	dst.Annotations = withoutAnnotation(src.Annotations, conversionDataAnnotation)
+	dst.Annotations[conversionDataAnnotation] = string(data)
+	return nil
+}
+
+// withoutAnnotation returns a copy of annotations without key, leaving the shared ObjectMeta map untouched
+func withoutAnnotation(annotations map[string]string, key string) map[string]string {
+	copied := make(map[string]string, len(annotations)+1)
+	for k, v := range annotations {
+		if k != key {
+			copied[k] = v
+		}
+	}
+	return copied
+}
+
+// convertTo converts the fields v1beta1 holds to v1
+func (src *Weather) convertTo(dst *weatherv1.Weather) {
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec.Lon = src.Spec.Lon
+	dst.Spec.Lat = src.Spec.Lat
+	if src.Spec.SecretRef != nil {
+		dst.Spec.SecretRef = &weatherv1.SecretRefSpec{Name: src.Spec.SecretRef.Name, Key: src.Spec.SecretRef.Key}
+	}
+	dst.Spec.RefreshPeriod = src.Spec.RefreshPeriod
+	dst.Spec.Provider = src.Spec.Provider
+	dst.Spec.Units = src.Spec.Units
+
+	dst.Status.CountryCode = src.Status.CountryCode
+	dst.Status.LocationName = src.Status.LocationName
+	dst.Status.Units = src.Status.Units
+	if len(src.Status.RefreshTime) == 0 {
+		// the weather has never been refreshed, so there are no measurements to convert
+		return
+	}
+	if refreshTime, err := time.Parse(refreshTimeLayout, src.Status.RefreshTime); err == nil {
+		t := metav1.NewTime(refreshTime)
+		dst.Status.RefreshTime = &t
+	}
+	// status written before status.units existed was always imperial
+	units := src.Status.Units
+	if len(units) == 0 {
+		units = weatherv1.UnitsImperial
+	}
+	dst.Status.Temp = parseMeasurement(src.Status.Temp, weatherv1.TemperatureUnit(units))
+	dst.Status.Pressure = &weatherv1.Measurement{Value: float64(src.Status.Pressure), Unit: weatherv1.UnitHectopascal}
+	dst.Status.Humidity = &weatherv1.Measurement{Value: float64(src.Status.Humidity), Unit: weatherv1.UnitPercent}
+	dst.Status.WindSpeed = parseMeasurement(src.Status.WindSpeed, weatherv1.SpeedUnit(units))
+	dst.Status.WindGust = parseMeasurement(src.Status.WindGust, weatherv1.SpeedUnit(units))
+}
+
+// convertFrom converts the fields v1beta1 holds from v1
+func (dst *Weather) convertFrom(src *weatherv1.Weather) {
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec.Lon = src.Spec.Lon
+	dst.Spec.Lat = src.Spec.Lat
+	// v1beta1 has no spec.location, so show the coordinates it resolved to
+	if src.Spec.Location != nil && src.Status.ResolvedCoordinates != nil {
+		dst.Spec.Lon = src.Status.ResolvedCoordinates.Lon
+		dst.Spec.Lat = src.Status.ResolvedCoordinates.Lat
+	}
+	if src.Spec.SecretRef != nil {
+		dst.Spec.SecretRef = &SecretRefSpec{Name: src.Spec.SecretRef.Name, Key: src.Spec.SecretRef.Key}
+	}
+	dst.Spec.RefreshPeriod = src.Spec.RefreshPeriod
+	dst.Spec.Provider = src.Spec.Provider
+	// v1beta1 has no spec.providers, so show the provider the data is from, or else the first of the chain
+	if len(src.Spec.Providers) > 0 {
+		provider := src.Spec.Providers[0]
+		for _, p := range src.Spec.Providers {
+			if p.Name == src.Status.Provider {
+				provider = p
+			}
+		}
+		dst.Spec.Provider = provider.Name
+		dst.Spec.SecretRef = nil
+		if provider.SecretRef != nil {
+			dst.Spec.SecretRef = &SecretRefSpec{Name: provider.SecretRef.Name, Key: provider.SecretRef.Key}
+		}
+	}
+	dst.Spec.Units = src.Spec.Units
+
+	dst.Status.CountryCode = src.Status.CountryCode
+	dst.Status.LocationName = src.Status.LocationName
+	dst.Status.Units = src.Status.Units
+	if src.Status.RefreshTime != nil {
+		dst.Status.RefreshTime = src.Status.RefreshTime.Time.String()
+	}
+	dst.Status.Temp = formatMeasurement(src.Status.Temp)
+	if src.Status.Pressure != nil {
+		dst.Status.Pressure = int64(math.Round(src.Status.Pressure.Value))
+	}
+	if src.Status.Humidity != nil {
+		dst.Status.Humidity = int64(math.Round(src.Status.Humidity.Value))
+	}
+	dst.Status.WindSpeed = formatMeasurement(src.Status.WindSpeed)
+	dst.Status.WindGust = formatMeasurement(src.Status.WindGust)
+}
+
+// parseMeasurement parses a "%.2f" formatted v1beta1 reading, returning nil if there is no reading
+func parseMeasurement(value string, unit string) *weatherv1.Measurement {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil
+	}
+	return &weatherv1.Measurement{Value: v, Unit: unit}
+}
+
+// formatMeasurement formats a reading the way v1beta1 has always stored it
+func formatMeasurement(m *weatherv1.Measurement) string {
+	if m == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.2f", m.Value)
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	weatherv1 "alsup/api/v1"
+)
+
+var _ = Describe("Weather conversion", func() {
+	newWeather := func() *Weather {
+		return &Weather{
+			ObjectMeta: metav1.ObjectMeta{Name: "sample", Namespace: "default"},
+			Spec: WeatherSpec{
+				Lat:           "38.44",
+				Lon:           "-77.98",
+				SecretRef:     &SecretRefSpec{Name: "weather-api-secret", Key: "token"},
+				RefreshPeriod: "3m",
+				Provider:      "openweathermap",
+				Units:         "metric",
+			},
+			Status: WeatherStatus{
+				RefreshTime:  "2022-04-15 01:20:00 -0400 EDT",
+				CountryCode:  "US",
+				LocationName: "Culpeper",
+				Temp:         "16.42",
+				Pressure:     1015,
+				Humidity:     41,
+				WindSpeed:    "2.57",
+				WindGust:     "5.41",
+				Units:        "metric",
+			},
+		}
+	}
+
+	It("converts measurements to structured v1 values", func() {
+		hub := &weatherv1.Weather{}
+		Expect(newWeather().ConvertTo(hub)).To(Succeed())
+
+		Expect(hub.Name).To(Equal("sample"))
+		Expect(hub.Spec.SecretRef).To(Equal(&weatherv1.SecretRefSpec{Name: "weather-api-secret", Key: "token"}))
+		Expect(hub.Spec.Units).To(Equal("metric"))
+		Expect(hub.Status.Temp).To(Equal(&weatherv1.Measurement{Value: 16.42, Unit: "degC"}))
+		Expect(hub.Status.Pressure).To(Equal(&weatherv1.Measurement{Value: 1015, Unit: "hPa"}))
+		Expect(hub.Status.Humidity).To(Equal(&weatherv1.Measurement{Value: 41, Unit: "%"}))
+		Expect(hub.Status.WindSpeed).To(Equal(&weatherv1.Measurement{Value: 2.57, Unit: "m/s"}))
+		Expect(hub.Status.WindGust).To(Equal(&weatherv1.Measurement{Value: 5.41, Unit: "m/s"}))
+		Expect(hub.Status.RefreshTime.UTC().Format("2006-01-02 15:04")).To(Equal("2022-04-15 05:20"))
+	})
+
+	It("assumes imperial units for status written before status.units existed", func() {
+		weather := newWeather()
+		weather.Status.Units = ""
+		hub := &weatherv1.Weather{}
+		Expect(weather.ConvertTo(hub)).To(Succeed())
+		Expect(hub.Status.Temp.Unit).To(Equal("degF"))
+		Expect(hub.Status.WindSpeed.Unit).To(Equal("mph"))
+	})
+
+	It("leaves measurements empty for a weather that was never refreshed", func() {
+		weather := newWeather()
+		weather.Status = WeatherStatus{}
+		hub := &weatherv1.Weather{}
+		Expect(weather.ConvertTo(hub)).To(Succeed())
+		Expect(hub.Status.Temp).To(BeNil())
+		Expect(hub.Status.Pressure).To(BeNil())
+		Expect(hub.Status.RefreshTime).To(BeNil())
+	})
+
+	It("shows the resolved coordinates of a v1 location", func() {
+		hub := &weatherv1.Weather{}
+		hub.Spec.Location = &weatherv1.LocationSpec{City: "Culpeper", CountryCode: "US"}
+		hub.Status.ResolvedCoordinates = &weatherv1.ResolvedCoordinates{Lat: "38.4731", Lon: "-77.9966"}
+		converted := &Weather{}
+		Expect(converted.ConvertFrom(hub)).To(Succeed())
+		Expect(converted.Spec.Lat).To(Equal("38.4731"))
+		Expect(converted.Spec.Lon).To(Equal("-77.9966"))
+	})
+
+	It("shows the active provider of a v1 provider chain", func() {
+		hub := &weatherv1.Weather{}
+		hub.Spec.Providers = []weatherv1.ProviderRef{
+			{Name: weatherv1.ProviderOpenWeatherMap, SecretRef: &weatherv1.SecretRefSpec{Name: "owm-secret", Key: "token"}},
+			{Name: weatherv1.ProviderOpenMeteo},
+		}
+		converted := &Weather{}
+		Expect(converted.ConvertFrom(hub)).To(Succeed())
+		Expect(converted.Spec.Provider).To(Equal(weatherv1.ProviderOpenWeatherMap))
+		Expect(converted.Spec.SecretRef.Name).To(Equal("owm-secret"))
+
+		hub.Status.Provider = weatherv1.ProviderOpenMeteo
+		Expect(converted.ConvertFrom(hub)).To(Succeed())
+		Expect(converted.Spec.Provider).To(Equal(weatherv1.ProviderOpenMeteo))
+		Expect(converted.Spec.SecretRef).To(BeNil())
+	})
+
+	It("round-trips through v1", func() {
+		original := newWeather()
+		hub := &weatherv1.Weather{}
+		Expect(original.ConvertTo(hub)).To(Succeed())
+
+		converted := &Weather{}
+		Expect(converted.ConvertFrom(hub)).To(Succeed())
+		Expect(converted.Annotations).To(HaveKey(conversionDataAnnotation))
+		converted.Annotations = nil
+		Expect(converted).To(Equal(original))
+	})
+
+	Context("with v1 fields v1beta1 cannot represent", func() {
+		var hub *weatherv1.Weather
+
+		BeforeEach(func() {
+			refreshTime := metav1.NewTime(time.Date(2022, 4, 15, 5, 20, 0, 0, time.UTC))
+			hub = &weatherv1.Weather{
+				ObjectMeta: metav1.ObjectMeta{Name: "sample", Namespace: "default", Annotations: map[string]string{"team": "ops"}},
+				Spec: weatherv1.WeatherSpec{
+					Location:      &weatherv1.LocationSpec{City: "Culpeper", CountryCode: "US"},
+					RefreshPeriod: "5m",
+					Provider:      weatherv1.ProviderOpenWeatherMap,
+					Providers: []weatherv1.ProviderRef{
+						{Name: weatherv1.ProviderOpenWeatherMap, SecretRef: &weatherv1.SecretRefSpec{Name: "owm", Key: "token", Namespace: "secrets"}},
+						{Name: weatherv1.ProviderOpenMeteo},
+					},
+					Units:      weatherv1.UnitsMetric,
+					Forecast:   &weatherv1.ForecastSpec{Periods: 4},
+					History:    &weatherv1.HistorySpec{Samples: 12},
+					Alerts:     true,
+					AirQuality: true,
+					Thresholds: []weatherv1.Threshold{{Name: "heat", Measurement: weatherv1.MeasurementTemp, Operator: weatherv1.ThresholdAbove, Value: 35}},
+					Notify:     []weatherv1.NotifyTarget{{Name: "hook", URLSecretRef: weatherv1.SecretKeyRef{Name: "hook", Key: "url"}}},
+				},
+				Status: weatherv1.WeatherStatus{
+					RefreshTime:         &refreshTime,
+					CountryCode:         "US",
+					LocationName:        "Culpeper",
+					Units:               weatherv1.UnitsMetric,
+					Temp:                &weatherv1.Measurement{Value: 16.42, Unit: "degC"},
+					Provider:            weatherv1.ProviderOpenMeteo,
+					ResolvedCoordinates: &weatherv1.ResolvedCoordinates{Lat: "38.4731", Lon: "-77.9966", Name: "Culpeper"},
+					Condition:           &weatherv1.WeatherCondition{Main: "Clear"},
+				},
+			}
+		})
+
+		It("round-trips them through v1beta1", func() {
+			spoke := &Weather{}
+			Expect(spoke.ConvertFrom(hub)).To(Succeed())
+			Expect(hub.Annotations).NotTo(HaveKey(conversionDataAnnotation))
+
+			restored := &weatherv1.Weather{}
+			Expect(spoke.ConvertTo(restored)).To(Succeed())
+			Expect(restored.Spec).To(Equal(hub.Spec))
+			Expect(restored.Status.ResolvedCoordinates).To(Equal(hub.Status.ResolvedCoordinates))
+			Expect(restored.Status.Provider).To(Equal(hub.Status.Provider))
+			Expect(restored.Status.Condition).To(Equal(hub.Status.Condition))
+			Expect(restored.Status.Temp).To(Equal(hub.Status.Temp))
+			Expect(restored.Status.RefreshTime.Equal(hub.Status.RefreshTime)).To(BeTrue())
+			Expect(restored.Annotations).To(Equal(map[string]string{"team": "ops"}))
+		})
+
+		It("applies the fields changed in v1beta1", func() {
+			spoke := &Weather{}
+			Expect(spoke.ConvertFrom(hub)).To(Succeed())
+			spoke.Spec.RefreshPeriod = "10m"
+			spoke.Spec.Lat, spoke.Spec.Lon = "38.5", "-78"
+			spoke.Spec.Provider, spoke.Spec.SecretRef = weatherv1.ProviderNWS, nil
+
+			restored := &weatherv1.Weather{}
+			Expect(spoke.ConvertTo(restored)).To(Succeed())
+			Expect(restored.Spec.RefreshPeriod).To(Equal("10m"))
+			Expect(restored.Spec.Location).To(BeNil())
+			Expect(restored.Spec.Lat).To(Equal("38.5"))
+			Expect(restored.Spec.Providers).To(BeNil())
+			Expect(restored.Spec.Provider).To(Equal(weatherv1.ProviderNWS))
+			Expect(restored.Spec.Forecast).To(Equal(hub.Spec.Forecast))
+			Expect(restored.Spec.Notify).To(Equal(hub.Spec.Notify))
+		})
+	})
+})
@@ -30,10 +30,22 @@ type SecretRefSpec struct {
 
 // WeatherSpec defines the desired state of Weather
 type WeatherSpec struct {
-	Lon           string        `json:"lon"`
-	Lat           string        `json:"lat"`
-	SecretRef     SecretRefSpec `json:"secretRef"`
-	RefreshPeriod string        `json:"refreshPeriod"`
+	Lon string `json:"lon"`
+	Lat string `json:"lat"`
+	// SecretRef holds the provider API token; optional for providers that need no token
+	//+optional
+	SecretRef     *SecretRefSpec `json:"secretRef,omitempty"`
+	RefreshPeriod string         `json:"refreshPeriod"`
+	// Provider is the upstream weather service used to fetch current conditions
+	//+kubebuilder:validation:Enum=openweathermap;nws;openmeteo
+	//+kubebuilder:default=openweathermap
+	//+optional
+	Provider string `json:"provider,omitempty"`
+	// Units is the unit system measurements are reported in: imperial (F, mph), metric (C, m/s) or standard (K, m/s)
+	//+kubebuilder:validation:Enum=imperial;metric;standard
+	//+kubebuilder:default=imperial
+	//+optional
+	Units string `json:"units,omitempty"`
 }
 
 // WeatherStatus defines the observed state of Weather
@@ -46,17 +58,21 @@ type WeatherStatus struct {
 	Humidity     int64  `json:"humidity"`
 	WindSpeed    string `json:"wind_speed"`
 	WindGust     string `json:"wind_gust"`
+	Units        string `json:"units,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:deprecatedversion:warning="weather.alsup/v1beta1 Weather is deprecated; use weather.alsup/v1 Weather"
 //+kubebuilder:printcolumn:name="Lat",type="string",JSONPath=".spec.lat",description="Latitude"
 //+kubebuilder:printcolumn:name="Lon",type="string",JSONPath=".spec.lon",description="Longitude"
 //+kubebuilder:printcolumn:name="Location",type="string",JSONPath=".status.location_name",description="Location"
 //+kubebuilder:printcolumn:name="Temp",type="string",JSONPath=".status.temp",description="Temp"
+//+kubebuilder:printcolumn:name="Units",type="string",JSONPath=".status.units",description="Unit system"
 //+kubebuilder:printcolumn:name="Refreshed",type="string",JSONPath=".status.refresh_time",description="Refreshed"
 
-// Weather is the Schema for the weathers API
+// Weather is the Schema for the weathers API.
+// v1beta1 is served by converting to and from v1, which stores measurements as structured values.
 type Weather struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -0,0 +1,94 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	weatherv1 "alsup/api/v1"
+)
+
+// errAirQualityNotSupported is returned for air quality from a provider that is not an AirQualityProvider
+var errAirQualityNotSupported = errors.New("provider does not support air quality")
+
+// airQualityCategories names the air quality indexes 1 to 5
+var airQualityCategories = []string{"Good", "Fair", "Moderate", "Poor", "Very Poor"}
+
+// AirQuality fetches the air pollution from the wrapped provider, when it is an AirQualityProvider
+func (p *rateLimitedProvider) AirQuality(ctx context.Context, req ObservationRequest) (*AirQuality, error) {
+	provider, ok := p.WeatherProvider.(AirQualityProvider)
+	if !ok {
+		return nil, errAirQualityNotSupported
+	}
+	if err := p.reserve(); err != nil {
+		return nil, err
+	}
+	return provider.AirQuality(ctx, req)
+}
+
+// updateAirQuality fetches the air pollution into status.airQuality when spec.airQuality is set. Air quality
+// is best effort: when the fetch fails a Warning event is emitted and the previous air quality is kept.
+func (r *WeatherReconciler) updateAirQuality(ctx context.Context, weather *weatherv1.Weather, providerName string, provider AirQualityProvider, req ObservationRequest) {
+	if !weather.Spec.AirQuality {
+		weather.Status.AirQuality = nil
+		return
+	}
+
+	aq, err := provider.AirQuality(ctx, req)
+	err = redact(err, req.Token)
+	if err != nil {
+		errMsg := "Unable to fetch air quality"
+		if errors.Is(err, errAirQualityNotSupported) {
+			errMsg = fmt.Sprintf("Provider '%s' does not support air quality", providerName)
+		}
+		log.FromContext(ctx).Error(err, errMsg)
+		r.Recorder.Event(weather, corev1.EventTypeWarning, "AirQuality", errMsg)
+		return
+	}
+
+	concentration := func(value float64) *weatherv1.Measurement {
+		return &weatherv1.Measurement{Value: round2(value), Unit: weatherv1.UnitMicrogramsPerCubicMeter}
+	}
+	status := &weatherv1.AirQuality{
+		Time:  metav1.NewTime(aq.Time),
+		Index: int32(aq.Index),
+		CO:    concentration(aq.CO),
+		NO2:   concentration(aq.NO2),
+		O3:    concentration(aq.O3),
+		SO2:   concentration(aq.SO2),
+		PM25:  concentration(aq.PM25),
+		PM10:  concentration(aq.PM10),
+	}
+	if aq.Index >= 1 && aq.Index <= len(airQualityCategories) {
+		status.Category = airQualityCategories[aq.Index-1]
+	}
+	weather.Status.AirQuality = status
+}
+
+// airQualityIndex returns the air quality index as a measurement, nil when it was not reported
+func airQualityIndex(status *weatherv1.WeatherStatus) *weatherv1.Measurement {
+	if status.AirQuality == nil {
+		return nil
+	}
+	return &weatherv1.Measurement{Value: float64(status.AirQuality.Index)}
+}
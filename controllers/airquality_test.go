@@ -0,0 +1,152 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	weatherv1 "alsup/api/v1"
+)
+
+// fakeAirQualityProvider is a fakeProvider that also returns a canned air pollution observation
+type fakeAirQualityProvider struct {
+	fakeProvider
+	airQuality    AirQuality
+	airQualityErr error
+}
+
+func (p *fakeAirQualityProvider) AirQuality(_ context.Context, _ ObservationRequest) (*AirQuality, error) {
+	if p.airQualityErr != nil {
+		return nil, p.airQualityErr
+	}
+	aq := p.airQuality
+	return &aq, nil
+}
+
+var _ = Describe("Weather air quality", func() {
+	var (
+		ctx      context.Context
+		provider *fakeAirQualityProvider
+		key      types.NamespacedName
+		weather  *weatherv1.Weather
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "sample"}
+		provider = &fakeAirQualityProvider{
+			fakeProvider: fakeProvider{obs: Observation{Time: time.Unix(1650000000, 0), LocationName: "Culpeper", Temp: 61.5}},
+			airQuality: AirQuality{
+				Time:  time.Unix(1650000000, 0),
+				Index: 4,
+				CO:    230.31,
+				NO2:   12.5,
+				O3:    68.66,
+				SO2:   1.19,
+				PM25:  55.456,
+				PM10:  80.1,
+			},
+		}
+		weather = newTestWeather()
+		weather.Spec.AirQuality = true
+	})
+
+	AfterEach(func() {
+		forgetWeatherMetrics(key)
+	})
+
+	It("stores the air quality index and pollutant concentrations in the status", func() {
+		r, _ := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		aq := weather.Status.AirQuality
+		Expect(aq).NotTo(BeNil())
+		Expect(aq.Time.Unix()).To(Equal(int64(1650000000)))
+		Expect(aq.Index).To(Equal(int32(4)))
+		Expect(aq.Category).To(Equal("Poor"))
+		Expect(aq.CO).To(Equal(&weatherv1.Measurement{Value: 230.31, Unit: "ug/m3"}))
+		Expect(aq.PM25).To(Equal(&weatherv1.Measurement{Value: 55.46, Unit: "ug/m3"}))
+		Expect(aq.PM10).To(Equal(&weatherv1.Measurement{Value: 80.1, Unit: "ug/m3"}))
+	})
+
+	It("exports the air quality as gauges", func() {
+		r, _ := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		labels := prometheus.Labels{"namespace": "default", "name": "sample", "location": "Culpeper"}
+		Expect(testutil.ToFloat64(weatherAirQualityIndex.With(labels))).To(Equal(4.0))
+		Expect(testutil.ToFloat64(weatherAirNO2.With(labels))).To(Equal(12.5))
+		Expect(testutil.ToFloat64(weatherAirPM25.With(labels))).To(Equal(55.46))
+	})
+
+	It("evaluates thresholds on air quality measurements", func() {
+		weather.Spec.Thresholds = []weatherv1.Threshold{
+			{Name: "unhealthy", Measurement: weatherv1.MeasurementAQI, Operator: weatherv1.ThresholdAbove, Value: 3},
+			{Name: "smoke", Measurement: weatherv1.MeasurementPM25, Operator: weatherv1.ThresholdAbove, Value: 35},
+		}
+		r, recorder := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Events).To(Receive(Equal("Warning ThresholdBreached Threshold 'unhealthy' breached: aqi is 4")))
+		Expect(recorder.Events).To(Receive(Equal("Warning ThresholdBreached Threshold 'smoke' breached: pm2_5 is 55.46 ug/m3")))
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.BreachedThresholds).To(Equal([]string{"unhealthy", "smoke"}))
+	})
+
+	It("keeps the previous air quality when it cannot be fetched", func() {
+		weather.Status.AirQuality = &weatherv1.AirQuality{Index: 2}
+		provider.airQualityErr = errors.New("boom")
+		r, recorder := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Events).To(Receive(Equal("Warning AirQuality Unable to fetch air quality")))
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.AirQuality.Index).To(Equal(int32(2)))
+	})
+
+	It("reports providers that do not support air quality", func() {
+		r, recorder := newTestReconciler(&provider.fakeProvider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Events).To(Receive(Equal("Warning AirQuality Provider 'fake' does not support air quality")))
+	})
+
+	It("clears the air quality when spec.airQuality is unset", func() {
+		weather.Spec.AirQuality = false
+		weather.Status.AirQuality = &weatherv1.AirQuality{Index: 2}
+		r, _ := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.AirQuality).To(BeNil())
+	})
+})
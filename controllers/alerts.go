@@ -0,0 +1,133 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	weatherv1 "alsup/api/v1"
+)
+
+// errAlertsNotSupported is returned for alerts from a provider that is not an AlertProvider
+var errAlertsNotSupported = errors.New("provider does not support alerts")
+
+// Alerts fetches the active alerts from the wrapped provider, when it is an AlertProvider
+func (p *rateLimitedProvider) Alerts(ctx context.Context, req ObservationRequest) ([]Alert, error) {
+	alerter, ok := p.WeatherProvider.(AlertProvider)
+	if !ok {
+		return nil, errAlertsNotSupported
+	}
+	if err := p.reserve(); err != nil {
+		return nil, err
+	}
+	return alerter.Alerts(ctx, req)
+}
+
+// updateAlerts fetches the active alerts into status.alerts when spec.alerts is set, sets the AlertActive
+// condition, and emits a Warning event for every alert raised or cleared since the last refresh. When the
+// fetch fails a Warning event is emitted and the previous alerts are kept.
+func (r *WeatherReconciler) updateAlerts(ctx context.Context, weather *weatherv1.Weather, providerName string, provider AlertProvider, req ObservationRequest) []statusEvent {
+	if !weather.Spec.Alerts {
+		weather.Status.Alerts = nil
+		meta.RemoveStatusCondition(&weather.Status.Conditions, weatherv1.ConditionAlertActive)
+		return nil
+	}
+
+	alerts, err := provider.Alerts(ctx, req)
+	err = redact(err, req.Token)
+	if err != nil {
+		errMsg := "Unable to fetch alerts"
+		if errors.Is(err, errAlertsNotSupported) {
+			errMsg = fmt.Sprintf("Provider '%s' does not support alerts", providerName)
+		}
+		log.FromContext(ctx).Error(err, errMsg)
+		r.Recorder.Event(weather, corev1.EventTypeWarning, "Alerts", errMsg)
+		return nil
+	}
+	if len(alerts) > weatherv1.MaxAlerts {
+		alerts = alerts[:weatherv1.MaxAlerts]
+	}
+
+	var events []statusEvent
+	previous := map[string]weatherv1.WeatherAlert{}
+	for _, alert := range weather.Status.Alerts {
+		previous[alert.Id] = alert
+	}
+	current := make([]weatherv1.WeatherAlert, 0, len(alerts))
+	for _, alert := range alerts {
+		statusAlert := weatherv1.WeatherAlert{
+			Id:       alert.Id,
+			Event:    alert.Event,
+			Headline: alert.Headline,
+			Severity: alert.Severity,
+			Sender:   alert.Sender,
+			Start:    optionalTime(alert.Start),
+			End:      optionalTime(alert.End),
+		}
+		current = append(current, statusAlert)
+		if _, ok := previous[alert.Id]; ok {
+			delete(previous, alert.Id)
+			continue
+		}
+		events = append(events, statusEvent{eventType: corev1.EventTypeWarning, reason: "AlertRaised", message: alertMessage(statusAlert)})
+	}
+	for _, alert := range weather.Status.Alerts {
+		if _, ok := previous[alert.Id]; ok {
+			events = append(events, statusEvent{eventType: corev1.EventTypeWarning, reason: "AlertCleared", message: fmt.Sprintf("%s cleared", alert.Event)})
+		}
+	}
+	weather.Status.Alerts = current
+
+	if len(current) == 0 {
+		setCondition(weather, weatherv1.ConditionAlertActive, metav1.ConditionFalse, ReasonNoAlerts, "No active alerts")
+		return events
+	}
+	active := make([]string, 0, len(current))
+	for _, alert := range current {
+		active = append(active, alert.Event)
+	}
+	setCondition(weather, weatherv1.ConditionAlertActive, metav1.ConditionTrue, ReasonAlertsActive,
+		fmt.Sprintf("Active alerts: %s", strings.Join(active, ", ")))
+	return events
+}
+
+// alertMessage describes a raised alert in an event
+func alertMessage(alert weatherv1.WeatherAlert) string {
+	msg := fmt.Sprintf("%s (severity %s)", alert.Event, alert.Severity)
+	if len(alert.Headline) > 0 {
+		msg += ": " + alert.Headline
+	}
+	return msg
+}
+
+// optionalTime converts a time that is zero when unknown
+func optionalTime(t time.Time) *metav1.Time {
+	if t.IsZero() {
+		return nil
+	}
+	mt := metav1.NewTime(t)
+	return &mt
+}
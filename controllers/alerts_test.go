@@ -0,0 +1,136 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	weatherv1 "alsup/api/v1"
+)
+
+// fakeAlertProvider is a fakeProvider that also returns canned alerts
+type fakeAlertProvider struct {
+	fakeProvider
+	alerts    []Alert
+	alertsErr error
+}
+
+func (p *fakeAlertProvider) Alerts(_ context.Context, _ ObservationRequest) ([]Alert, error) {
+	if p.alertsErr != nil {
+		return nil, p.alertsErr
+	}
+	return p.alerts, nil
+}
+
+var _ = Describe("Weather alerts", func() {
+	var (
+		ctx      context.Context
+		provider *fakeAlertProvider
+		key      types.NamespacedName
+		weather  *weatherv1.Weather
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "sample"}
+		provider = &fakeAlertProvider{
+			fakeProvider: fakeProvider{obs: Observation{Time: time.Unix(1650000000, 0), Temp: 61.5}},
+			alerts: []Alert{{
+				Id:       "storm",
+				Event:    "Severe Thunderstorm Warning",
+				Headline: "Severe Thunderstorm Warning until 6:00PM EDT",
+				Severity: "Severe",
+				Start:    time.Unix(1650057120, 0),
+			}},
+		}
+		weather = newTestWeather()
+		weather.Spec.Alerts = true
+	})
+
+	AfterEach(func() {
+		forgetWeatherMetrics(key)
+	})
+
+	It("stores new alerts and emits a Warning event", func() {
+		r, recorder := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Events).To(Receive(Equal("Warning AlertRaised Severe Thunderstorm Warning (severity Severe): Severe Thunderstorm Warning until 6:00PM EDT")))
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Alerts).To(HaveLen(1))
+		Expect(weather.Status.Alerts[0].Id).To(Equal("storm"))
+		Expect(weather.Status.Alerts[0].Start.Unix()).To(Equal(int64(1650057120)))
+		Expect(weather.Status.Alerts[0].End).To(BeNil())
+		Expect(meta.IsStatusConditionTrue(weather.Status.Conditions, weatherv1.ConditionAlertActive)).To(BeTrue())
+	})
+
+	It("only emits events when alerts appear or clear", func() {
+		weather.Status.Alerts = []weatherv1.WeatherAlert{
+			{Id: "storm", Event: "Severe Thunderstorm Warning"},
+			{Id: "wind", Event: "Wind Advisory"},
+		}
+		r, recorder := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Events).To(Receive(Equal("Warning AlertCleared Wind Advisory cleared")))
+		Expect(recorder.Events).NotTo(Receive(ContainSubstring("Alert")))
+	})
+
+	It("clears the AlertActive condition when no alerts are active", func() {
+		provider.alerts = nil
+		r, _ := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Alerts).To(BeEmpty())
+		Expect(meta.IsStatusConditionFalse(weather.Status.Conditions, weatherv1.ConditionAlertActive)).To(BeTrue())
+	})
+
+	It("keeps the previous alerts when the alerts cannot be fetched", func() {
+		weather.Status.Alerts = []weatherv1.WeatherAlert{{Id: "wind", Event: "Wind Advisory"}}
+		provider.alertsErr = errors.New("boom")
+		r, recorder := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Events).To(Receive(Equal("Warning Alerts Unable to fetch alerts")))
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Alerts).To(ConsistOf(weatherv1.WeatherAlert{Id: "wind", Event: "Wind Advisory"}))
+	})
+
+	It("does not fetch alerts unless spec.alerts is set", func() {
+		weather.Spec.Alerts = false
+		weather.Status.Alerts = []weatherv1.WeatherAlert{{Id: "wind", Event: "Wind Advisory"}}
+		r, recorder := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Events).NotTo(Receive(ContainSubstring("Alert")))
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Alerts).To(BeEmpty())
+	})
+})
@@ -0,0 +1,142 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultObservationCacheTTL is how long an observation is shared between Weathers for the same place
+const DefaultObservationCacheTTL = time.Minute
+
+// ObservationCache shares provider observations between Weathers for the same place, so they
+// are fetched once per TTL. Observations are keyed by provider, units and coordinates rounded
+// to 2 decimals (about 1km). A nil cache, or one with no TTL, always calls the provider.
+type ObservationCache struct {
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*observationCacheEntry
+	now     func() time.Time
+}
+
+// observationCacheEntry is a cached observation, or a fetch in progress when done is still open
+type observationCacheEntry struct {
+	done    chan struct{}
+	fetched bool
+	obs     *Observation
+	err     error
+	expires time.Time
+}
+
+func NewObservationCache(ttl time.Duration) *ObservationCache {
+	return &ObservationCache{
+		TTL:     ttl,
+		entries: map[string]*observationCacheEntry{},
+		now:     time.Now,
+	}
+}
+
+// CurrentConditions returns the cached observation for the request, fetching it from the provider
+// when it is missing or expired. Concurrent requests for the same key wait for a single fetch.
+func (c *ObservationCache) CurrentConditions(ctx context.Context, provider WeatherProvider, req ObservationRequest) (*Observation, error) {
+	key, ok := observationCacheKey(provider.Name(), req)
+	if c == nil || c.TTL <= 0 || !ok {
+		return provider.CurrentConditions(ctx, req)
+	}
+
+	c.mu.Lock()
+	entry, found := c.entries[key]
+	if found && entry.fetched && !c.now().Before(entry.expires) {
+		found = false
+	}
+	if !found {
+		c.pruneLocked()
+		entry = &observationCacheEntry{done: make(chan struct{})}
+		c.entries[key] = entry
+		c.mu.Unlock()
+		return c.fetch(ctx, provider, req, key, entry)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-entry.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if entry.err != nil {
+		// the shared fetch failed (e.g. a bad token), so retry with this request's own token
+		observationCacheMisses.WithLabelValues(provider.Name()).Inc()
+		return provider.CurrentConditions(ctx, req)
+	}
+	observationCacheHits.WithLabelValues(provider.Name()).Inc()
+	obs := *entry.obs
+	return &obs, nil
+}
+
+// fetch calls the provider for a new cache entry, and shares the result with requests waiting on it
+func (c *ObservationCache) fetch(ctx context.Context, provider WeatherProvider, req ObservationRequest, key string, entry *observationCacheEntry) (*Observation, error) {
+	observationCacheMisses.WithLabelValues(provider.Name()).Inc()
+	obs, err := provider.CurrentConditions(ctx, req)
+
+	c.mu.Lock()
+	entry.err = err
+	if err != nil {
+		if c.entries[key] == entry {
+			delete(c.entries, key)
+		}
+	} else {
+		entry.obs = obs
+		entry.fetched = true
+		entry.expires = c.now().Add(c.TTL)
+	}
+	c.mu.Unlock()
+	close(entry.done)
+
+	if err != nil {
+		return nil, err
+	}
+	shared := *obs
+	return &shared, nil
+}
+
+// pruneLocked drops expired entries, e.g. of deleted Weathers; c.mu must be held
+func (c *ObservationCache) pruneLocked() {
+	now := c.now()
+	for key, entry := range c.entries {
+		if entry.fetched && !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// observationCacheKey returns the cache key of a request, or false when its coordinates are not numeric
+func observationCacheKey(provider string, req ObservationRequest) (string, bool) {
+	lat, err := strconv.ParseFloat(req.Lat, 64)
+	if err != nil {
+		return "", false
+	}
+	lon, err := strconv.ParseFloat(req.Lon, 64)
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%s/%s/%.2f,%.2f", provider, req.Units, lat, lon), true
+}
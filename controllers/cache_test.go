@@ -0,0 +1,119 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("ObservationCache", func() {
+	var (
+		ctx      context.Context
+		provider *fakeProvider
+		cache    *ObservationCache
+		now      time.Time
+		req      ObservationRequest
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		provider = &fakeProvider{obs: Observation{Temp: 61.5}}
+		now = time.Unix(1650000000, 0)
+		cache = NewObservationCache(time.Minute)
+		cache.now = func() time.Time { return now }
+		req = ObservationRequest{Lat: "38.4465", Lon: "-77.9883", Units: UnitsImperial, Token: "secret-token"}
+	})
+
+	It("shares an observation between requests for nearby coordinates", func() {
+		hits := testutil.ToFloat64(observationCacheHits.WithLabelValues("fake"))
+		misses := testutil.ToFloat64(observationCacheMisses.WithLabelValues("fake"))
+
+		obs, err := cache.CurrentConditions(ctx, provider, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obs.Temp).To(Equal(61.5))
+
+		nearby := req
+		nearby.Lat, nearby.Lon = "38.448", "-77.9851"
+		obs, err = cache.CurrentConditions(ctx, provider, nearby)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obs.Temp).To(Equal(61.5))
+
+		Expect(provider.requests).To(HaveLen(1))
+		Expect(testutil.ToFloat64(observationCacheHits.WithLabelValues("fake"))).To(Equal(hits + 1))
+		Expect(testutil.ToFloat64(observationCacheMisses.WithLabelValues("fake"))).To(Equal(misses + 1))
+	})
+
+	It("keys observations by units and rounded coordinates", func() {
+		_, err := cache.CurrentConditions(ctx, provider, req)
+		Expect(err).NotTo(HaveOccurred())
+
+		metric := req
+		metric.Units = UnitsMetric
+		_, err = cache.CurrentConditions(ctx, provider, metric)
+		Expect(err).NotTo(HaveOccurred())
+
+		elsewhere := req
+		elsewhere.Lat = "38.46"
+		_, err = cache.CurrentConditions(ctx, provider, elsewhere)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(provider.requests).To(HaveLen(3))
+	})
+
+	It("fetches again once the TTL expired", func() {
+		_, err := cache.CurrentConditions(ctx, provider, req)
+		Expect(err).NotTo(HaveOccurred())
+		now = now.Add(time.Minute)
+		_, err = cache.CurrentConditions(ctx, provider, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(HaveLen(2))
+	})
+
+	It("does not cache failures", func() {
+		provider.err = errors.New("boom")
+		_, err := cache.CurrentConditions(ctx, provider, req)
+		Expect(err).To(HaveOccurred())
+		provider.err = nil
+		_, err = cache.CurrentConditions(ctx, provider, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(HaveLen(2))
+	})
+
+	It("returns copies that callers may modify", func() {
+		obs, err := cache.CurrentConditions(ctx, provider, req)
+		Expect(err).NotTo(HaveOccurred())
+		obs.Temp = 0
+		obs, err = cache.CurrentConditions(ctx, provider, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obs.Temp).To(Equal(61.5))
+	})
+
+	It("always calls the provider when disabled", func() {
+		var disabled *ObservationCache
+		for i := 0; i < 2; i++ {
+			_, err := disabled.CurrentConditions(ctx, provider, req)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(provider.requests).To(HaveLen(2))
+	})
+})
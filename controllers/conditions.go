@@ -0,0 +1,177 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	weatherv1 "alsup/api/v1"
+)
+
+// Condition reasons set by Reconcile
+const (
+	ReasonUnknownProvider           = "UnknownProvider"
+	ReasonSecretRefMissing          = "SecretRefMissing"
+	ReasonSecretNotFound            = "SecretNotFound"
+	ReasonSecretNamespaceNotAllowed = "SecretNamespaceNotAllowed"
+	ReasonSecretKeyMissing          = "SecretKeyMissing"
+	ReasonSecretResolved            = "SecretResolved"
+	ReasonTokenNotRequired          = "TokenNotRequired"
+	ReasonProviderError             = "ProviderError"
+	ReasonUnauthorized              = "Unauthorized"
+	ReasonNotFound                  = "NotFound"
+	ReasonDecodeFailed              = "DecodeFailed"
+	ReasonRateLimited               = "RateLimited"
+	ReasonGeocodingFailed           = "GeocodingFailed"
+	ReasonLocationNotFound          = "LocationNotFound"
+	ReasonObservationFetched        = "ObservationFetched"
+	ReasonRefreshFailed             = "RefreshFailed"
+	ReasonAlertsActive              = "AlertsActive"
+	ReasonNoAlerts                  = "NoAlerts"
+	ReasonThresholdBreached         = "ThresholdBreached"
+	ReasonWithinThresholds          = "WithinThresholds"
+)
+
+// parkedReasons are the Ready reasons of failures that retrying cannot fix: the weather is not refreshed
+// again until its spec or secret changes
+var parkedReasons = map[string]bool{
+	ReasonUnauthorized:     true,
+	ReasonNotFound:         true,
+	ReasonLocationNotFound: true,
+}
+
+// statusEvent is an event reporting a status change. It is emitted once the status is written, so a failed
+// write does not report the change twice.
+type statusEvent struct {
+	eventType string
+	reason    string
+	message   string
+}
+
+// setCondition sets a status condition, stamped with the generation of the weather spec
+func setCondition(weather *weatherv1.Weather, condType string, status metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&weather.Status.Conditions, metav1.Condition{
+		Type:               condType,
+		Status:             status,
+		ObservedGeneration: weather.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// setRefreshed marks the weather Ready with up-to-date measurements
+func setRefreshed(weather *weatherv1.Weather, providerName string) {
+	msg := fmt.Sprintf("Weather refreshed from provider '%s'", providerName)
+	setCondition(weather, weatherv1.ConditionProviderReachable, metav1.ConditionTrue, ReasonObservationFetched, msg)
+	setCondition(weather, weatherv1.ConditionReady, metav1.ConditionTrue, ReasonObservationFetched, msg)
+	setCondition(weather, weatherv1.ConditionStale, metav1.ConditionFalse, ReasonObservationFetched, msg)
+	weather.Status.ObservedGeneration = weather.Generation
+}
+
+// reportFailure records a failed refresh: condType and Ready are set False with the given reason, the
+// measurements are marked Stale, a Warning event is emitted and the status is written
+func (r *WeatherReconciler) reportFailure(ctx context.Context, weather *weatherv1.Weather, condType string, reason string, eventReason string, msg string) {
+	setCondition(weather, condType, metav1.ConditionFalse, reason, msg)
+	setCondition(weather, weatherv1.ConditionReady, metav1.ConditionFalse, reason, msg)
+	staleMsg := "No weather data has been fetched yet"
+	if weather.Status.RefreshTime != nil {
+		staleMsg = fmt.Sprintf("Weather data is from %s", weather.Status.RefreshTime.UTC().Format(time.RFC3339))
+	}
+	setCondition(weather, weatherv1.ConditionStale, metav1.ConditionTrue, ReasonRefreshFailed, staleMsg)
+	weather.Status.ObservedGeneration = weather.Generation
+
+	r.Recorder.Event(weather, corev1.EventTypeWarning, eventReason, msg)
+	if err := r.Client.Status().Update(ctx, weather); err != nil {
+		log.FromContext(ctx).Error(err, "Unable to post failure status to weather")
+	}
+}
+
+// reportProviderError records a failed provider call according to its kind and returns how to requeue:
+// transient errors are retried with exponential backoff, rate limited calls once the limit resets and decode
+// failures on the regular schedule. Rejected tokens and locations are not retried, the weather is parked
+// until its spec or secret changes. The tokens are redacted from err, which is logged and returned.
+func (r *WeatherReconciler) reportProviderError(ctx context.Context, weather *weatherv1.Weather, providerName string, msg string, err error, tokens []string, refreshPeriod time.Duration) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("provider", providerName)
+	err = redact(err, tokens...)
+
+	var rateLimited *RateLimitedError
+	if errors.As(err, &rateLimited) {
+		// wait for the rate limit to reset instead of retrying with exponential backoff. The message leaves out
+		// the delay, which changes on every call: a changed status would trigger another throttled reconcile.
+		errMsg := "Provider rate limit reached, retrying once it resets"
+		logger.Info(errMsg, "retryAfter", rateLimited.RetryAfter.String())
+		r.reportFailure(ctx, weather, weatherv1.ConditionProviderReachable, ReasonRateLimited, ReasonRateLimited, errMsg)
+		return ctrl.Result{RequeueAfter: rateLimited.RetryAfter}, nil
+	}
+	var notFound *LocationNotFoundError
+	if errors.As(err, &notFound) {
+		errMsg := fmt.Sprintf("Provider '%s' cannot find %s", providerName, notFound.Query)
+		logger.Error(err, errMsg)
+		r.reportFailure(ctx, weather, weatherv1.ConditionProviderReachable, ReasonLocationNotFound, ReasonLocationNotFound, errMsg)
+		return ctrl.Result{}, nil
+	}
+
+	var reason, detail string
+	switch errorKind(err) {
+	case ErrorAuth:
+		reason, detail = ReasonUnauthorized, "the API token was rejected"
+	case ErrorNotFound:
+		reason, detail = ReasonNotFound, "the location was rejected or is not covered"
+	case ErrorDecode:
+		reason, detail = ReasonDecodeFailed, "the response could not be parsed"
+	default:
+		reason, detail = ReasonProviderError, "the provider is unavailable"
+	}
+	errMsg := fmt.Sprintf("%s: %s", msg, detail)
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) && providerErr.StatusCode != 0 {
+		errMsg += fmt.Sprintf(" (status-code %d)", providerErr.StatusCode)
+	}
+	logger.Error(err, errMsg)
+	r.reportFailure(ctx, weather, weatherv1.ConditionProviderReachable, reason, reason, errMsg)
+
+	switch {
+	case parkedReasons[reason]:
+		return ctrl.Result{}, nil
+	case reason == ReasonDecodeFailed:
+		return ctrl.Result{RequeueAfter: refreshPeriod}, nil
+	default:
+		return ctrl.Result{}, err
+	}
+}
+
+// parked reports whether the latest refresh failed in a way retrying cannot fix, and neither the spec nor
+// the secrets the tokens are read from changed since. secretVersion is the secretResourceVersion of the chain.
+func parked(weather *weatherv1.Weather, secretVersion string) bool {
+	ready := meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionReady)
+	if ready == nil || ready.Status != metav1.ConditionFalse || !parkedReasons[ready.Reason] {
+		return false
+	}
+	if ready.ObservedGeneration != weather.Generation {
+		return false
+	}
+	return secretVersion == weather.Status.SecretResourceVersion
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	weatherv1 "alsup/api/v1"
+)
+
+// failsOver reports whether the next provider of the chain is tried after err: the provider is unavailable
+// or rate limited. Rejected tokens and locations are reported instead, as the next provider cannot fix them.
+func failsOver(err error) bool {
+	var rateLimited *RateLimitedError
+	if errors.As(err, &rateLimited) {
+		return true
+	}
+	var notFound *LocationNotFoundError
+	if errors.As(err, &notFound) {
+		return false
+	}
+	return errorKind(err) == ErrorTransient
+}
+
+// secretResourceVersion joins the resourceVersions of the secrets the chain's tokens were read from
+func secretResourceVersion(chain []*resolvedProvider) string {
+	var versions []string
+	for _, resolved := range chain {
+		if resolved.secret != nil {
+			versions = append(versions, resolved.secret.ResourceVersion)
+		}
+	}
+	return strings.Join(versions, ",")
+}
+
+// chainTokens returns the API tokens of the chain, which are redacted from provider errors
+func chainTokens(chain []*resolvedProvider) []string {
+	var tokens []string
+	for _, resolved := range chain {
+		if len(resolved.token) > 0 {
+			tokens = append(tokens, resolved.token)
+		}
+	}
+	return tokens
+}
+
+// setActiveProvider records in status.provider the provider the current data is from. A change is reported
+// with the returned event, a Warning when failing over from the first provider and Normal when returning to it.
+func setActiveProvider(weather *weatherv1.Weather, providerName string, primary bool) []statusEvent {
+	previous := weather.Status.Provider
+	weather.Status.Provider = providerName
+	if len(previous) == 0 || previous == providerName {
+		return nil
+	}
+	eventType := corev1.EventTypeWarning
+	if primary {
+		eventType = corev1.EventTypeNormal
+	}
+	msg := fmt.Sprintf("Active provider changed from '%s' to '%s'", previous, providerName)
+	return []statusEvent{{eventType: eventType, reason: "ProviderChanged", message: msg}}
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	weatherv1 "alsup/api/v1"
+)
+
+// backupProvider is a tokenless fakeProvider named "backup", for the second provider of a chain
+type backupProvider struct {
+	fakeProvider
+}
+
+func (p *backupProvider) Name() string {
+	return "backup"
+}
+
+var _ = Describe("Weather provider failover", func() {
+	var (
+		ctx     context.Context
+		primary *fakeProvider
+		backup  *backupProvider
+		key     types.NamespacedName
+		weather *weatherv1.Weather
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "sample"}
+		primary = &fakeProvider{obs: Observation{Time: time.Unix(1650000000, 0), Temp: 61.5}}
+		backup = &backupProvider{fakeProvider{obs: Observation{Time: time.Unix(1650000000, 0), Temp: 60}, tokenless: true}}
+		weather = newTestWeather()
+		weather.Spec.Provider, weather.Spec.SecretRef = "", nil
+		weather.Spec.Providers = []weatherv1.ProviderRef{
+			{Name: "fake", SecretRef: &weatherv1.SecretRefSpec{Name: "weather-api-secret", Key: "token"}},
+			{Name: "backup"},
+		}
+	})
+
+	newFailoverReconciler := func() (*WeatherReconciler, chan string) {
+		r, recorder := newTestReconciler(primary, weather, newTestSecret())
+		r.Providers["backup"] = backup
+		return r, recorder.Events
+	}
+
+	// drain returns the events recorded since the last drain, one per line
+	drain := func(events chan string) string {
+		var out strings.Builder
+		for len(events) > 0 {
+			out.WriteString(<-events + "\n")
+		}
+		return out.String()
+	}
+
+	refetch := func(r *WeatherReconciler) {
+		current := &weatherv1.Weather{}
+		Expect(r.Client.Get(ctx, key, current)).To(Succeed())
+		current.Status.LastFetchTime = nil
+		Expect(r.Client.Status().Update(ctx, current)).To(Succeed())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("uses the first provider of the chain while it is available", func() {
+		r, _ := newFailoverReconciler()
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(primary.requests).To(HaveLen(1))
+		Expect(primary.requests[0].Token).To(Equal("secret-token"))
+		Expect(backup.requests).To(BeEmpty())
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Provider).To(Equal("fake"))
+		Expect(weather.Status.Temp.Value).To(Equal(61.5))
+		Expect(meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionSecretResolved).Message).To(Equal(
+			"API token read from key 'token' of secret 'default/weather-api-secret'; Provider 'backup' does not require an API token"))
+	})
+
+	It("fails over to the next provider while a provider is unavailable", func() {
+		primary.err = context.DeadlineExceeded
+		r, events := newFailoverReconciler()
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(backup.requests).To(HaveLen(1))
+		Expect(backup.requests[0].Token).To(BeEmpty())
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Provider).To(Equal("backup"))
+		Expect(weather.Status.Temp.Value).To(Equal(60.0))
+		Expect(meta.IsStatusConditionTrue(weather.Status.Conditions, weatherv1.ConditionReady)).To(BeTrue())
+		Expect(meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionReady).Message).To(Equal("Weather refreshed from provider 'backup'"))
+		Expect(drain(events)).NotTo(ContainSubstring("ProviderChanged"))
+
+		primary.err = &RateLimitedError{Provider: "fake", RetryAfter: time.Minute}
+		refetch(r)
+		Expect(backup.requests).To(HaveLen(2))
+		Expect(drain(events)).NotTo(ContainSubstring("ProviderChanged"))
+
+		primary.err = nil
+		refetch(r)
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Provider).To(Equal("fake"))
+		Expect(drain(events)).To(ContainSubstring("Normal ProviderChanged Active provider changed from 'backup' to 'fake'"))
+
+		primary.err = statusCodeError("fake", http.StatusServiceUnavailable)
+		refetch(r)
+		Expect(drain(events)).To(ContainSubstring("Warning ProviderChanged Active provider changed from 'fake' to 'backup'"))
+	})
+
+	It("reports the failure of the last provider when all are unavailable", func() {
+		primary.err = context.DeadlineExceeded
+		backup.err = statusCodeError("backup", http.StatusBadGateway)
+		r, _ := newFailoverReconciler()
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).To(HaveOccurred())
+		Expect(primary.requests).To(HaveLen(1))
+		Expect(backup.requests).To(HaveLen(1))
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		ready := meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionReady)
+		Expect(ready.Reason).To(Equal(ReasonProviderError))
+		Expect(ready.Message).To(Equal("Unable to query weather API: the provider is unavailable (status-code 502)"))
+	})
+
+	It("names the active provider when it does not support a feature", func() {
+		primary.err = context.DeadlineExceeded
+		weather.Spec.Forecast = &weatherv1.ForecastSpec{}
+		r, events := newFailoverReconciler()
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(drain(events)).To(ContainSubstring("Warning Forecast Provider 'backup' does not support forecasts"))
+	})
+
+	It("does not fail over when the token is rejected", func() {
+		primary.err = statusCodeError("fake", http.StatusUnauthorized)
+		r, _ := newFailoverReconciler()
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(backup.requests).To(BeEmpty())
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionReady).Reason).To(Equal(ReasonUnauthorized))
+		Expect(weather.Status.SecretResourceVersion).NotTo(BeEmpty())
+	})
+
+	It("skips a provider whose secret cannot be read", func() {
+		backup.tokenless = false
+		weather.Spec.Providers[1].SecretRef = &weatherv1.SecretRefSpec{Name: "missing-secret", Key: "token"}
+		r, events := newFailoverReconciler()
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(primary.requests).To(HaveLen(1))
+		Expect(drain(events)).To(ContainSubstring("Warning Secret Cannot find secret 'default/missing-secret', skipping the provider"))
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Provider).To(Equal("fake"))
+		Expect(meta.IsStatusConditionTrue(weather.Status.Conditions, weatherv1.ConditionReady)).To(BeTrue())
+		secretResolved := meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionSecretResolved)
+		Expect(secretResolved.Status).To(Equal(metav1.ConditionFalse))
+		Expect(secretResolved.Reason).To(Equal(ReasonSecretNotFound))
+		Expect(secretResolved.Message).To(Equal(
+			"API token read from key 'token' of secret 'default/weather-api-secret'; Cannot find secret 'default/missing-secret'"))
+	})
+
+	It("uses the next provider when the secret of the first cannot be read", func() {
+		weather.Spec.Providers[0].SecretRef.Name = "missing-secret"
+		r, _ := newFailoverReconciler()
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(primary.requests).To(BeEmpty())
+		Expect(backup.requests).To(HaveLen(1))
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Provider).To(Equal("backup"))
+		Expect(meta.IsStatusConditionTrue(weather.Status.Conditions, weatherv1.ConditionReady)).To(BeTrue())
+	})
+
+	It("fails when no provider of the chain can be resolved", func() {
+		weather.Spec.Providers[0].SecretRef.Name = "missing-secret"
+		weather.Spec.Providers[1].Name = "unknown"
+		r, _ := newFailoverReconciler()
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(primary.requests).To(BeEmpty())
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		ready := meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionReady)
+		Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+		Expect(ready.Reason).To(Equal(ReasonSecretNotFound))
+	})
+
+	It("maps a changed secret of any provider of the chain to the weather", func() {
+		weather.Spec.Providers[1].SecretRef = &weatherv1.SecretRefSpec{Name: "backup-secret"}
+		r, _ := newFailoverReconciler()
+		Expect(secretRefName(weather)).To(Equal([]string{"weather-api-secret", "backup-secret"}))
+		backupSecret := &corev1.Secret{}
+		backupSecret.Namespace, backupSecret.Name = "default", "backup-secret"
+		Expect(r.weathersForSecret(backupSecret)).To(ConsistOf(reconcile.Request{NamespacedName: key}))
+	})
+})
@@ -0,0 +1,84 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	weatherv1 "alsup/api/v1"
+)
+
+// errForecastNotSupported is returned for forecasts from a provider that is not a ForecastProvider
+var errForecastNotSupported = errors.New("provider does not support forecasts")
+
+// Forecast fetches a forecast from the wrapped provider, when it is a ForecastProvider
+func (p *rateLimitedProvider) Forecast(ctx context.Context, req ObservationRequest, periods int) ([]ForecastPeriod, error) {
+	forecaster, ok := p.WeatherProvider.(ForecastProvider)
+	if !ok {
+		return nil, errForecastNotSupported
+	}
+	if err := p.reserve(); err != nil {
+		return nil, err
+	}
+	return forecaster.Forecast(ctx, req, periods)
+}
+
+// updateForecast fetches the forecast requested by spec.forecast into status.forecast. Forecasts are best
+// effort: when the fetch fails a Warning event is emitted and the previous forecast is kept.
+func (r *WeatherReconciler) updateForecast(ctx context.Context, weather *weatherv1.Weather, providerName string, provider ForecastProvider, req ObservationRequest) {
+	if weather.Spec.Forecast == nil {
+		weather.Status.Forecast = nil
+		return
+	}
+	periods := weather.Spec.Forecast.Periods
+	if periods <= 0 {
+		periods = weatherv1.DefaultForecastPeriods
+	} else if periods > weatherv1.MaxForecastPeriods {
+		periods = weatherv1.MaxForecastPeriods
+	}
+
+	forecast, err := provider.Forecast(ctx, req, periods)
+	err = redact(err, req.Token)
+	if err != nil {
+		errMsg := "Unable to fetch forecast"
+		if errors.Is(err, errForecastNotSupported) {
+			errMsg = fmt.Sprintf("Provider '%s' does not support forecasts", providerName)
+		}
+		log.FromContext(ctx).Error(err, errMsg)
+		r.Recorder.Event(weather, corev1.EventTypeWarning, "Forecast", errMsg)
+		return
+	}
+	if len(forecast) > periods {
+		forecast = forecast[:periods]
+	}
+
+	weather.Status.Forecast = make([]weatherv1.ForecastPeriod, 0, len(forecast))
+	for _, period := range forecast {
+		weather.Status.Forecast = append(weather.Status.Forecast, weatherv1.ForecastPeriod{
+			Time:                     metav1.NewTime(period.Time),
+			Temp:                     &weatherv1.Measurement{Value: round2(period.Temp), Unit: weatherv1.TemperatureUnit(req.Units)},
+			PrecipitationProbability: &weatherv1.Measurement{Value: round2(period.PrecipitationProbability), Unit: weatherv1.UnitPercent},
+			Summary:                  period.Summary,
+		})
+	}
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	weatherv1 "alsup/api/v1"
+)
+
+// fakeForecastProvider is a fakeProvider that also returns canned forecasts
+type fakeForecastProvider struct {
+	fakeProvider
+	forecast    []ForecastPeriod
+	forecastErr error
+	periods     []int
+}
+
+func (p *fakeForecastProvider) Forecast(_ context.Context, _ ObservationRequest, periods int) ([]ForecastPeriod, error) {
+	p.periods = append(p.periods, periods)
+	if p.forecastErr != nil {
+		return nil, p.forecastErr
+	}
+	return p.forecast, nil
+}
+
+var _ = Describe("Weather forecast", func() {
+	var (
+		ctx      context.Context
+		provider *fakeForecastProvider
+		key      types.NamespacedName
+		weather  *weatherv1.Weather
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "sample"}
+		provider = &fakeForecastProvider{
+			fakeProvider: fakeProvider{obs: Observation{Time: time.Unix(1650000000, 0), Temp: 61.5}},
+			forecast: []ForecastPeriod{
+				{Time: time.Unix(1650006000, 0), Temp: 63.123, PrecipitationProbability: 0, Summary: "few clouds"},
+				{Time: time.Unix(1650016800, 0), Temp: 58.4, PrecipitationProbability: 62, Summary: "light rain"},
+				{Time: time.Unix(1650027600, 0), Temp: 55, PrecipitationProbability: 80, Summary: "rain"},
+			},
+		}
+		weather = newTestWeather()
+		weather.Spec.Forecast = &weatherv1.ForecastSpec{Periods: 2}
+	})
+
+	AfterEach(func() {
+		forgetWeatherMetrics(key)
+	})
+
+	It("stores a bounded list of forecast periods in the status", func() {
+		r, _ := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.periods).To(Equal([]int{2}))
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Forecast).To(HaveLen(2))
+		Expect(weather.Status.Forecast[0].Time.Unix()).To(Equal(int64(1650006000)))
+		Expect(weather.Status.Forecast[0].Temp).To(Equal(&weatherv1.Measurement{Value: 63.12, Unit: "degF"}))
+		Expect(weather.Status.Forecast[1].PrecipitationProbability).To(Equal(&weatherv1.Measurement{Value: 62, Unit: "%"}))
+		Expect(weather.Status.Forecast[1].Summary).To(Equal("light rain"))
+	})
+
+	It("keeps the previous forecast when the forecast cannot be fetched", func() {
+		weather.Status.Forecast = []weatherv1.ForecastPeriod{{Summary: "previous"}}
+		provider.forecastErr = errors.New("boom")
+		r, recorder := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Events).To(Receive(Equal("Warning Forecast Unable to fetch forecast")))
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Forecast).To(ConsistOf(weatherv1.ForecastPeriod{Summary: "previous"}))
+	})
+
+	It("reports providers that do not support forecasts", func() {
+		r, recorder := newTestReconciler(&provider.fakeProvider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Events).To(Receive(Equal("Warning Forecast Provider 'fake' does not support forecasts")))
+	})
+
+	It("clears the forecast when spec.forecast is removed", func() {
+		weather.Spec.Forecast = nil
+		weather.Status.Forecast = []weatherv1.ForecastPeriod{{Summary: "previous"}}
+		r, _ := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Forecast).To(BeEmpty())
+		Expect(provider.periods).To(BeEmpty())
+	})
+})
@@ -0,0 +1,71 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	weatherv1 "alsup/api/v1"
+)
+
+// errGeocodingNotSupported is returned for spec.location with a provider that is not a Geocoder
+var errGeocodingNotSupported = errors.New("provider does not support geocoding")
+
+// Geocode resolves a place through the wrapped provider, when it is a Geocoder
+func (p *rateLimitedProvider) Geocode(ctx context.Context, req GeocodeRequest) (*Location, error) {
+	geocoder, ok := p.WeatherProvider.(Geocoder)
+	if !ok {
+		return nil, errGeocodingNotSupported
+	}
+	if err := p.reserve(); err != nil {
+		return nil, err
+	}
+	return geocoder.Geocode(ctx, req)
+}
+
+// coordinates returns the coordinates to query the weather for: spec.lat and spec.lon, or the coordinates
+// spec.location resolves to. A location is only geocoded when status.resolvedCoordinates is missing or was
+// resolved from a different location, so renaming a city costs one geocoding call rather than one per refresh.
+func (r *WeatherReconciler) coordinates(ctx context.Context, weather *weatherv1.Weather, geocoder Geocoder, token string) (string, string, error) {
+	location := weather.Spec.Location
+	if location == nil {
+		weather.Status.ResolvedCoordinates = nil
+		return weather.Spec.Lat, weather.Spec.Lon, nil
+	}
+	if resolved := weather.Status.ResolvedCoordinates; resolved != nil && resolved.Location == *location {
+		return resolved.Lat, resolved.Lon, nil
+	}
+
+	resolved, err := geocoder.Geocode(ctx, GeocodeRequest{
+		City:        location.City,
+		CountryCode: location.CountryCode,
+		Zip:         location.Zip,
+		Token:       token,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	weather.Status.ResolvedCoordinates = &weatherv1.ResolvedCoordinates{
+		Lat:      strconv.FormatFloat(resolved.Lat, 'f', -1, 64),
+		Lon:      strconv.FormatFloat(resolved.Lon, 'f', -1, 64),
+		Name:     resolved.Name,
+		Location: *location,
+	}
+	return weather.Status.ResolvedCoordinates.Lat, weather.Status.ResolvedCoordinates.Lon, nil
+}
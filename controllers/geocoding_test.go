@@ -0,0 +1,158 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	weatherv1 "alsup/api/v1"
+)
+
+// fakeGeocodingProvider is a fakeProvider that also resolves every place to a canned location
+type fakeGeocodingProvider struct {
+	fakeProvider
+	location   Location
+	geocodeErr error
+	geocodes   []GeocodeRequest
+}
+
+func (p *fakeGeocodingProvider) Geocode(_ context.Context, req GeocodeRequest) (*Location, error) {
+	p.geocodes = append(p.geocodes, req)
+	if p.geocodeErr != nil {
+		return nil, p.geocodeErr
+	}
+	location := p.location
+	return &location, nil
+}
+
+var _ = Describe("Weather geocoding", func() {
+	var (
+		ctx      context.Context
+		provider *fakeGeocodingProvider
+		key      types.NamespacedName
+		weather  *weatherv1.Weather
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "sample"}
+		provider = &fakeGeocodingProvider{
+			fakeProvider: fakeProvider{obs: Observation{Time: time.Unix(1650000000, 0), Temp: 61.5}},
+			location:     Location{Lat: 38.4731, Lon: -77.9966, Name: "Culpeper"},
+		}
+		weather = newTestWeather()
+		weather.Spec.Lat, weather.Spec.Lon = "", ""
+		weather.Spec.Location = &weatherv1.LocationSpec{City: "Culpeper", CountryCode: "US"}
+	})
+
+	AfterEach(func() {
+		forgetWeatherMetrics(key)
+	})
+
+	It("resolves spec.location into status.resolvedCoordinates", func() {
+		r, _ := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.geocodes).To(ConsistOf(GeocodeRequest{City: "Culpeper", CountryCode: "US", Token: "secret-token"}))
+		Expect(provider.requests).To(ConsistOf(ObservationRequest{Lat: "38.4731", Lon: "-77.9966", Units: UnitsImperial, Token: "secret-token"}))
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.ResolvedCoordinates).To(Equal(&weatherv1.ResolvedCoordinates{
+			Lat:      "38.4731",
+			Lon:      "-77.9966",
+			Name:     "Culpeper",
+			Location: weatherv1.LocationSpec{City: "Culpeper", CountryCode: "US"},
+		}))
+	})
+
+	It("only resolves the location again when spec.location changes", func() {
+		r, _ := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		// a refresh reuses the resolved coordinates
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		weather.Status.LastFetchTime = nil
+		Expect(r.Client.Status().Update(ctx, weather)).To(Succeed())
+		_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.geocodes).To(HaveLen(1))
+		Expect(provider.requests).To(HaveLen(2))
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		weather.Spec.Location = &weatherv1.LocationSpec{Zip: "22701", CountryCode: "US"}
+		weather.Generation++
+		Expect(r.Client.Update(ctx, weather)).To(Succeed())
+		_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.geocodes).To(HaveLen(2))
+		Expect(provider.geocodes[1]).To(Equal(GeocodeRequest{Zip: "22701", CountryCode: "US", Token: "secret-token"}))
+	})
+
+	It("clears the resolved coordinates when spec.location is removed", func() {
+		weather.Spec.Location = nil
+		weather.Spec.Lat, weather.Spec.Lon = "38.44", "-77.98"
+		weather.Status.ResolvedCoordinates = &weatherv1.ResolvedCoordinates{Lat: "38.4731", Lon: "-77.9966"}
+		r, _ := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.geocodes).To(BeEmpty())
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.ResolvedCoordinates).To(BeNil())
+	})
+
+	It("does not retry locations the provider cannot find", func() {
+		provider.geocodeErr = &LocationNotFoundError{Query: "Culpeper,US"}
+		r, recorder := newTestReconciler(provider, weather, newTestSecret())
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{}))
+		Expect(provider.requests).To(BeEmpty())
+		Expect(recorder.Events).To(Receive(Equal("Warning LocationNotFound Provider 'fake' cannot find Culpeper,US")))
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		cond := meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionProviderReachable)
+		Expect(cond.Reason).To(Equal(ReasonLocationNotFound))
+	})
+
+	It("retries when geocoding fails", func() {
+		provider.geocodeErr = errors.New("boom")
+		r, _ := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).To(HaveOccurred())
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		cond := meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionProviderReachable)
+		Expect(cond.Reason).To(Equal(ReasonProviderError))
+	})
+
+	It("reports providers that cannot geocode", func() {
+		r, recorder := newTestReconciler(&provider.fakeProvider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Events).To(Receive(ContainSubstring("Provider 'fake' does not support geocoding")))
+	})
+})
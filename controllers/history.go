@@ -0,0 +1,58 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	weatherv1 "alsup/api/v1"
+)
+
+// recordHistory appends the current measurements to status.history, dropping the oldest samples beyond
+// spec.history.samples. An observation the provider already reported (e.g. a cached one) replaces the last
+// sample rather than being recorded twice.
+func recordHistory(weather *weatherv1.Weather) {
+	if weather.Spec.History == nil {
+		weather.Status.History = nil
+		return
+	}
+	if weather.Status.RefreshTime == nil {
+		return
+	}
+	samples := weather.Spec.History.Samples
+	if samples <= 0 {
+		samples = weatherv1.DefaultHistorySamples
+	} else if samples > weatherv1.MaxHistorySamples {
+		samples = weatherv1.MaxHistorySamples
+	}
+
+	status := &weather.Status
+	sample := weatherv1.HistorySample{
+		Time:      *status.RefreshTime,
+		Temp:      status.Temp,
+		Pressure:  status.Pressure,
+		Humidity:  status.Humidity,
+		WindSpeed: status.WindSpeed,
+		WindGust:  status.WindGust,
+	}
+	if n := len(status.History); n > 0 && status.History[n-1].Time.Equal(&sample.Time) {
+		status.History[n-1] = sample
+	} else {
+		status.History = append(status.History, sample)
+	}
+	if len(status.History) > samples {
+		status.History = status.History[len(status.History)-samples:]
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	weatherv1 "alsup/api/v1"
+)
+
+var _ = Describe("Weather history", func() {
+	observe := func(weather *weatherv1.Weather, ts int64, temp float64) {
+		refreshTime := metav1.NewTime(time.Unix(ts, 0))
+		weather.Status.RefreshTime = &refreshTime
+		weather.Status.Temp = &weatherv1.Measurement{Value: temp, Unit: "degF"}
+		recordHistory(weather)
+	}
+	temps := func(weather *weatherv1.Weather) []float64 {
+		var values []float64
+		for _, sample := range weather.Status.History {
+			values = append(values, sample.Temp.Value)
+		}
+		return values
+	}
+
+	It("keeps the last spec.history.samples observations, oldest first", func() {
+		weather := newTestWeather()
+		weather.Spec.History = &weatherv1.HistorySpec{Samples: 3}
+		for i, temp := range []float64{60, 61, 62, 63} {
+			observe(weather, 1650000000+int64(i)*300, temp)
+		}
+		Expect(temps(weather)).To(Equal([]float64{61, 62, 63}))
+		Expect(weather.Status.History[2].Time.Unix()).To(Equal(int64(1650000900)))
+	})
+
+	It("does not record the same observation twice", func() {
+		weather := newTestWeather()
+		weather.Spec.History = &weatherv1.HistorySpec{Samples: 3}
+		observe(weather, 1650000000, 60)
+		observe(weather, 1650000000, 60.5)
+		Expect(temps(weather)).To(Equal([]float64{60.5}))
+	})
+
+	It("shrinks and clears the history with spec.history", func() {
+		weather := newTestWeather()
+		weather.Spec.History = &weatherv1.HistorySpec{Samples: 3}
+		for i, temp := range []float64{60, 61, 62} {
+			observe(weather, 1650000000+int64(i)*300, temp)
+		}
+		weather.Spec.History.Samples = 1
+		observe(weather, 1650001200, 64)
+		Expect(temps(weather)).To(Equal([]float64{64}))
+
+		weather.Spec.History = nil
+		observe(weather, 1650001500, 65)
+		Expect(weather.Status.History).To(BeNil())
+	})
+
+	It("records the observation of each refresh in the status", func() {
+		key := types.NamespacedName{Namespace: "default", Name: "sample"}
+		defer forgetWeatherMetrics(key)
+		weather := newTestWeather()
+		weather.Spec.History = &weatherv1.HistorySpec{Samples: 5}
+		provider := &fakeProvider{obs: Observation{Time: time.Unix(1650000000, 0), Temp: 61.5, Humidity: int64Ptr(40)}}
+		r, _ := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(r.Client.Get(context.Background(), key, weather)).To(Succeed())
+		Expect(weather.Status.History).To(HaveLen(1))
+		Expect(weather.Status.History[0].Time.Unix()).To(Equal(int64(1650000000)))
+		Expect(weather.Status.History[0].Temp).To(Equal(&weatherv1.Measurement{Value: 61.5, Unit: "degF"}))
+		Expect(weather.Status.History[0].Humidity).To(Equal(&weatherv1.Measurement{Value: 40, Unit: "%"}))
+	})
+})
@@ -0,0 +1,250 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	weatherv1 "alsup/api/v1"
+)
+
+// Labels of the per-Weather gauges
+var weatherLabelNames = []string{"namespace", "name", "location"}
+var weatherUnitLabelNames = []string{"namespace", "name", "location", "unit"}
+
+// Gauges exported for every Weather, served on the manager's /metrics endpoint
+var (
+	weatherTemperature = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "weather_temperature",
+		Help: "Current temperature, in the unit given by the unit label",
+	}, weatherUnitLabelNames)
+	weatherPressure = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "weather_pressure_hpa",
+		Help: "Current sea level pressure in hPa",
+	}, weatherLabelNames)
+	weatherHumidity = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "weather_humidity_percent",
+		Help: "Current relative humidity in percent",
+	}, weatherLabelNames)
+	weatherWindSpeed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "weather_wind_speed",
+		Help: "Current wind speed, in the unit given by the unit label",
+	}, weatherUnitLabelNames)
+	weatherWindGust = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "weather_wind_gust",
+		Help: "Current wind gust speed, in the unit given by the unit label",
+	}, weatherUnitLabelNames)
+)
+
+// Air quality gauges, exported for every Weather with spec.airQuality
+var (
+	weatherAirQualityIndex = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "weather_air_quality_index",
+		Help: "Current air quality index, from 1 (good) to 5 (very poor)",
+	}, weatherLabelNames)
+	weatherAirCO = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "weather_air_co_ug_m3",
+		Help: "Current carbon monoxide concentration in µg/m³",
+	}, weatherLabelNames)
+	weatherAirNO2 = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "weather_air_no2_ug_m3",
+		Help: "Current nitrogen dioxide concentration in µg/m³",
+	}, weatherLabelNames)
+	weatherAirO3 = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "weather_air_o3_ug_m3",
+		Help: "Current ozone concentration in µg/m³",
+	}, weatherLabelNames)
+	weatherAirSO2 = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "weather_air_so2_ug_m3",
+		Help: "Current sulphur dioxide concentration in µg/m³",
+	}, weatherLabelNames)
+	weatherAirPM25 = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "weather_air_pm2_5_ug_m3",
+		Help: "Current fine particulate matter (PM2.5) concentration in µg/m³",
+	}, weatherLabelNames)
+	weatherAirPM10 = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "weather_air_pm10_ug_m3",
+		Help: "Current coarse particulate matter (PM10) concentration in µg/m³",
+	}, weatherLabelNames)
+)
+
+// Provider call metrics, labelled by provider
+var (
+	providerRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "weather_provider_request_duration_seconds",
+		Help: "Latency of HTTP requests to the weather provider",
+		// WeatherAPITimeout is the upper bound, slower requests are counted as timeouts
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, WeatherAPITimeout.Seconds()},
+	}, []string{"provider"})
+	providerRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "weather_provider_requests_total",
+		Help: "HTTP requests to the weather provider by status code, or \"error\" when no response was received",
+	}, []string{"provider", "code"})
+	providerTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "weather_provider_timeouts_total",
+		Help: "HTTP requests to the weather provider that timed out",
+	}, []string{"provider"})
+	providerParseFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "weather_provider_parse_failures_total",
+		Help: "Weather provider responses that could not be parsed",
+	}, []string{"provider"})
+	providerThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "weather_provider_throttled_total",
+		Help: "Weather provider calls deferred by the operator's rate limiter",
+	}, []string{"provider"})
+	providerSecretCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "weather_provider_secret_calls_total",
+		Help: "Weather provider calls made with the API token of a secret, to track API quotas",
+	}, []string{"provider", "secret_namespace", "secret"})
+)
+
+// Observation cache metrics, labelled by provider
+var (
+	observationCacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "weather_observation_cache_hits_total",
+		Help: "Observations served from the shared observation cache",
+	}, []string{"provider"})
+	observationCacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "weather_observation_cache_misses_total",
+		Help: "Observations fetched from the provider because they were not cached",
+	}, []string{"provider"})
+)
+
+// weatherGauge maps a status measurement to the gauge exporting it
+type weatherGauge struct {
+	vec         *prometheus.GaugeVec
+	withUnit    bool
+	measurement func(*weatherv1.WeatherStatus) *weatherv1.Measurement
+}
+
+var weatherGauges = []weatherGauge{
+	{weatherTemperature, true, func(s *weatherv1.WeatherStatus) *weatherv1.Measurement { return s.Temp }},
+	{weatherPressure, false, func(s *weatherv1.WeatherStatus) *weatherv1.Measurement { return s.Pressure }},
+	{weatherHumidity, false, func(s *weatherv1.WeatherStatus) *weatherv1.Measurement { return s.Humidity }},
+	{weatherWindSpeed, true, func(s *weatherv1.WeatherStatus) *weatherv1.Measurement { return s.WindSpeed }},
+	{weatherWindGust, true, func(s *weatherv1.WeatherStatus) *weatherv1.Measurement { return s.WindGust }},
+	{weatherAirQualityIndex, false, airQualityIndex},
+	{weatherAirCO, false, namedMeasurement(weatherv1.MeasurementCO)},
+	{weatherAirNO2, false, namedMeasurement(weatherv1.MeasurementNO2)},
+	{weatherAirO3, false, namedMeasurement(weatherv1.MeasurementO3)},
+	{weatherAirSO2, false, namedMeasurement(weatherv1.MeasurementSO2)},
+	{weatherAirPM25, false, namedMeasurement(weatherv1.MeasurementPM25)},
+	{weatherAirPM10, false, namedMeasurement(weatherv1.MeasurementPM10)},
+}
+
+// namedMeasurement returns a function reading the status measurement a threshold would watch by that name
+func namedMeasurement(name string) func(*weatherv1.WeatherStatus) *weatherv1.Measurement {
+	return func(s *weatherv1.WeatherStatus) *weatherv1.Measurement { return statusMeasurement(s, name) }
+}
+
+// weatherSeries remembers the labels each Weather was last exported with, so stale series
+// (e.g. after a location or unit change, or deletion) can be removed
+var (
+	weatherSeriesMu sync.Mutex
+	weatherSeries   = map[types.NamespacedName]map[*prometheus.GaugeVec]prometheus.Labels{}
+)
+
+func init() {
+	for _, g := range weatherGauges {
+		metrics.Registry.MustRegister(g.vec)
+	}
+	metrics.Registry.MustRegister(providerRequestDuration, providerRequests, providerTimeouts, providerParseFailures,
+		providerThrottled, providerSecretCalls, observationCacheHits, observationCacheMisses)
+}
+
+// recordWeatherMetrics exports the measurements in the weather status
+func recordWeatherMetrics(weather *weatherv1.Weather) {
+	key := client.ObjectKeyFromObject(weather)
+	series := map[*prometheus.GaugeVec]prometheus.Labels{}
+
+	weatherSeriesMu.Lock()
+	defer weatherSeriesMu.Unlock()
+	for _, g := range weatherGauges {
+		m := g.measurement(&weather.Status)
+		if m == nil {
+			continue
+		}
+		labels := prometheus.Labels{
+			"namespace": weather.Namespace,
+			"name":      weather.Name,
+			"location":  weather.Status.LocationName,
+		}
+		if g.withUnit {
+			labels["unit"] = m.Unit
+		}
+		g.vec.With(labels).Set(m.Value)
+		series[g.vec] = labels
+	}
+	for vec, labels := range weatherSeries[key] {
+		if !reflect.DeepEqual(series[vec], labels) {
+			vec.Delete(labels)
+		}
+	}
+	weatherSeries[key] = series
+}
+
+// forgetWeatherMetrics removes the series exported for a deleted weather
+func forgetWeatherMetrics(key types.NamespacedName) {
+	weatherSeriesMu.Lock()
+	defer weatherSeriesMu.Unlock()
+	for vec, labels := range weatherSeries[key] {
+		vec.Delete(labels)
+	}
+	delete(weatherSeries, key)
+}
+
+// instrumentedTransport records the provider call metrics for every HTTP request
+type instrumentedTransport struct {
+	provider string
+	next     http.RoundTripper
+}
+
+// newProviderHttpClient returns an HTTP client for a provider, bounded by WeatherAPITimeout and instrumented
+func newProviderHttpClient(provider string) *http.Client {
+	return &http.Client{
+		Timeout:   WeatherAPITimeout,
+		Transport: &instrumentedTransport{provider: provider, next: http.DefaultTransport},
+	}
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	providerRequestDuration.WithLabelValues(t.provider).Observe(time.Since(start).Seconds())
+	if err != nil {
+		providerRequests.WithLabelValues(t.provider, "error").Inc()
+		// http.Client enforces its Timeout through the request context
+		if os.IsTimeout(err) || errors.Is(req.Context().Err(), context.DeadlineExceeded) {
+			providerTimeouts.WithLabelValues(t.provider).Inc()
+		}
+		return nil, err
+	}
+	providerRequests.WithLabelValues(t.provider, strconv.Itoa(resp.StatusCode)).Inc()
+	return resp, nil
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	weatherv1 "alsup/api/v1"
+)
+
+var _ = Describe("Weather metrics", func() {
+	var (
+		ctx      context.Context
+		provider *fakeProvider
+		key      types.NamespacedName
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "sample"}
+		provider = &fakeProvider{obs: Observation{
+			Time:         time.Unix(1650000000, 0),
+			LocationName: "Culpeper",
+			Temp:         61.5,
+			Pressure:     int64Ptr(1015),
+			Humidity:     int64Ptr(40),
+			WindSpeed:    float64Ptr(5.75),
+			WindGust:     float64Ptr(12.1),
+		}}
+	})
+
+	AfterEach(func() {
+		forgetWeatherMetrics(key)
+	})
+
+	labels := func(unit string) prometheus.Labels {
+		l := prometheus.Labels{"namespace": "default", "name": "sample", "location": "Culpeper"}
+		if len(unit) > 0 {
+			l["unit"] = unit
+		}
+		return l
+	}
+
+	It("exports the observation as gauges", func() {
+		r, _ := newTestReconciler(provider, newTestWeather(), newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(testutil.ToFloat64(weatherTemperature.With(labels("degF")))).To(Equal(61.5))
+		Expect(testutil.ToFloat64(weatherPressure.With(labels("")))).To(Equal(1015.0))
+		Expect(testutil.ToFloat64(weatherHumidity.With(labels("")))).To(Equal(40.0))
+		Expect(testutil.ToFloat64(weatherWindSpeed.With(labels("mph")))).To(Equal(5.75))
+		Expect(testutil.ToFloat64(weatherWindGust.With(labels("mph")))).To(Equal(12.1))
+	})
+
+	It("replaces series whose labels changed", func() {
+		weather := newTestWeather()
+		r, _ := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		weather.Spec.Units = UnitsMetric
+		weather.Generation++
+		Expect(r.Client.Update(ctx, weather)).To(Succeed())
+		_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(testutil.CollectAndCount(weatherTemperature)).To(Equal(1))
+		Expect(testutil.ToFloat64(weatherTemperature.With(labels("degC")))).To(Equal(61.5))
+	})
+
+	It("removes the series of a deleted weather", func() {
+		weather := newTestWeather()
+		r, _ := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(testutil.CollectAndCount(weatherPressure)).To(Equal(1))
+
+		Expect(r.Client.Delete(ctx, &weatherv1.Weather{ObjectMeta: weather.ObjectMeta})).To(Succeed())
+		_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		for _, g := range weatherGauges {
+			Expect(testutil.CollectAndCount(g.vec)).To(BeZero())
+		}
+	})
+})
+
+var _ = Describe("Provider call metrics", func() {
+	var server *httptest.Server
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/slow":
+				time.Sleep(200 * time.Millisecond)
+			case "/garbage":
+				_, _ = w.Write([]byte("not json"))
+			case "/missing":
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("counts requests by status code and observes their latency", func() {
+		client := newProviderHttpClient("metrics-test")
+		before := testutil.ToFloat64(providerRequests.WithLabelValues("metrics-test", "404"))
+		resp, err := client.Get(server.URL + "/missing")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+		Expect(testutil.ToFloat64(providerRequests.WithLabelValues("metrics-test", "404"))).To(Equal(before + 1))
+		Expect(testutil.CollectAndCount(providerRequestDuration)).To(BeNumerically(">=", 1))
+	})
+
+	It("counts timeouts", func() {
+		client := newProviderHttpClient("metrics-timeout-test")
+		client.Timeout = 50 * time.Millisecond
+		_, err := client.Get(server.URL + "/slow")
+		Expect(err).To(HaveOccurred())
+		Expect(testutil.ToFloat64(providerTimeouts.WithLabelValues("metrics-timeout-test"))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(providerRequests.WithLabelValues("metrics-timeout-test", "error"))).To(Equal(1.0))
+	})
+
+	It("counts responses that cannot be parsed", func() {
+		p := NewOpenWeatherMapProvider()
+		p.BaseUrl = server.URL + "/garbage"
+		before := testutil.ToFloat64(providerParseFailures.WithLabelValues(p.Name()))
+		_, err := p.CurrentConditions(context.Background(), ObservationRequest{Lat: "38.44", Lon: "-77.98"})
+		Expect(err).To(HaveOccurred())
+		Expect(testutil.ToFloat64(providerParseFailures.WithLabelValues(p.Name()))).To(Equal(before + 1))
+	})
+
+	It("counts calls made with each secret", func() {
+		provider := &fakeProvider{obs: Observation{Time: time.Unix(1650000000, 0)}}
+		r, _ := newTestReconciler(provider, newTestWeather(), newTestSecret())
+		key := types.NamespacedName{Namespace: "default", Name: "sample"}
+		defer forgetWeatherMetrics(key)
+		calls := providerSecretCalls.WithLabelValues("fake", "default", "weather-api-secret")
+		before := testutil.ToFloat64(calls)
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(testutil.ToFloat64(calls)).To(Equal(before + 1))
+	})
+})
@@ -0,0 +1,403 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/json"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	weatherv1 "alsup/api/v1"
+)
+
+// NotifySignatureHeader carries the HMAC-SHA256 signature of a notification payload, as "sha256=<hex>"
+const NotifySignatureHeader = "X-Weather-Signature"
+
+// Notifier defaults
+const (
+	DefaultNotifyAttempts  = 3
+	DefaultNotifyBackoff   = time.Second
+	DefaultNotifyTimeout   = 30 * time.Second
+	DefaultNotifyWorkers   = 4
+	DefaultNotifyQueueSize = 100
+)
+
+// NotificationPayload is the JSON body posted to spec.notify targets when the measurements change
+type NotificationPayload struct {
+	Namespace    string `json:"namespace"`
+	Name         string `json:"name"`
+	LocationName string `json:"locationName,omitempty"`
+	// Changed lists the changed measurements as in the Updated event, suffixed with +/- when comparable
+	Changed     []string               `json:"changed"`
+	RefreshTime *metav1.Time           `json:"refreshTime,omitempty"`
+	Units       string                 `json:"units,omitempty"`
+	Temp        *weatherv1.Measurement `json:"temp,omitempty"`
+	Pressure    *weatherv1.Measurement `json:"pressure,omitempty"`
+	Humidity    *weatherv1.Measurement `json:"humidity,omitempty"`
+	WindSpeed   *weatherv1.Measurement `json:"windSpeed,omitempty"`
+	WindGust    *weatherv1.Measurement `json:"windGust,omitempty"`
+}
+
+// Notifier posts notification payloads to webhook targets, retrying failed deliveries with exponential backoff.
+// Deliveries are queued and made by background workers, so slow targets do not hold up reconciles; their
+// outcomes are collected by the next reconcile of the weather, which the Notifier triggers through Events.
+type Notifier struct {
+	HttpClient *http.Client
+	// Attempts is the maximum number of requests made for one delivery
+	Attempts int
+	// Backoff is the delay before the first retry, doubled before every further retry
+	Backoff time.Duration
+	// Timeout bounds a whole delivery, retries included
+	Timeout time.Duration
+	// Workers is the number of deliveries made concurrently
+	Workers int
+
+	queue  chan notifyJob
+	events chan event.GenericEvent
+
+	mu       sync.Mutex
+	outcomes map[types.NamespacedName]map[string]notifyOutcome
+}
+
+// notifyJob is a queued delivery to one target
+type notifyJob struct {
+	weather types.NamespacedName
+	target  string
+	url     string
+	hmacKey []byte
+	payload []byte
+}
+
+// notifyOutcome is the result of a finished delivery, waiting to be recorded in status.notifications
+type notifyOutcome struct {
+	attemptTime time.Time
+	// successTime is when a delivery last succeeded since the outcomes were last collected, zero if none did
+	successTime time.Time
+	attempts    int
+	statusCode  int
+	err         error
+}
+
+// NewNotifier returns a Notifier queueing up to queueSize deliveries
+func NewNotifier(queueSize int) *Notifier {
+	return &Notifier{
+		HttpClient: &http.Client{Timeout: WeatherAPITimeout},
+		Attempts:   DefaultNotifyAttempts,
+		Backoff:    DefaultNotifyBackoff,
+		Timeout:    DefaultNotifyTimeout,
+		Workers:    DefaultNotifyWorkers,
+		queue:      make(chan notifyJob, queueSize),
+		events:     make(chan event.GenericEvent, queueSize),
+		outcomes:   map[types.NamespacedName]map[string]notifyOutcome{},
+	}
+}
+
+// Start runs the delivery workers until ctx is done, implementing manager.Runnable
+func (n *Notifier) Start(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for i := 0; i < n.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case job := <-n.queue:
+					n.deliver(ctx, job)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// Events emits a weather whenever a delivery for it finished, so its outcome gets recorded
+func (n *Notifier) Events() <-chan event.GenericEvent {
+	return n.events
+}
+
+// enqueue queues a delivery, reporting false when the queue is full
+func (n *Notifier) enqueue(job notifyJob) bool {
+	select {
+	case n.queue <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// deliver makes a queued delivery within Timeout and stores its outcome
+func (n *Notifier) deliver(ctx context.Context, job notifyJob) {
+	ctx, cancel := context.WithTimeout(ctx, n.Timeout)
+	defer cancel()
+	outcome := notifyOutcome{attemptTime: time.Now()}
+	outcome.attempts, outcome.statusCode, outcome.err = n.Deliver(ctx, job.url, job.hmacKey, job.payload)
+	outcome.err = redact(outcome.err, job.url, string(job.hmacKey))
+	if outcome.err == nil {
+		outcome.successTime = outcome.attemptTime
+	}
+	n.record(job.weather, job.target, outcome)
+}
+
+// record stores the outcome of a delivery to target and triggers a reconcile of the weather to collect it
+func (n *Notifier) record(key types.NamespacedName, target string, outcome notifyOutcome) {
+	n.mu.Lock()
+	targets, ok := n.outcomes[key]
+	if !ok {
+		targets = map[string]notifyOutcome{}
+		n.outcomes[key] = targets
+	}
+	if previous, ok := targets[target]; ok && outcome.successTime.IsZero() {
+		outcome.successTime = previous.successTime
+	}
+	targets[target] = outcome
+	n.mu.Unlock()
+
+	weather := &weatherv1.Weather{ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name}}
+	select {
+	case n.events <- event.GenericEvent{Object: weather}:
+	default:
+		// a reconcile is already pending, or the outcome is collected with the next refresh
+	}
+}
+
+// forget drops the outcomes not collected yet for a deleted weather
+func (n *Notifier) forget(key types.NamespacedName) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.outcomes, key)
+}
+
+// takeOutcomes returns and forgets the outcomes of the finished deliveries for a weather, by target name
+func (n *Notifier) takeOutcomes(weather types.NamespacedName) map[string]notifyOutcome {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	outcomes := n.outcomes[weather]
+	delete(n.outcomes, weather)
+	return outcomes
+}
+
+// Deliver posts a payload to target, signed with hmacKey when it is set. It returns the number of requests
+// made and the HTTP status of the last one (0 when there was no response). Network errors, 429 and 5xx
+// responses are retried. Errors never include the target URL, which usually embeds a credential.
+func (n *Notifier) Deliver(ctx context.Context, target string, hmacKey []byte, payload []byte) (int, int, error) {
+	backoff := n.Backoff
+	var statusCode int
+	var err error
+	attempt := 0
+	for attempt < n.Attempts {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return attempt, statusCode, ctx.Err()
+			}
+			backoff *= 2
+		}
+		attempt++
+
+		var retry bool
+		statusCode, retry, err = n.post(ctx, target, hmacKey, payload)
+		if err == nil || !retry {
+			break
+		}
+	}
+	return attempt, statusCode, err
+}
+
+// post makes a single delivery request, reporting whether a failure may be retried
+func (n *Notifier) post(ctx context.Context, target string, hmacKey []byte, payload []byte) (int, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(payload))
+	if err != nil {
+		return 0, false, errors.New("invalid notification URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(hmacKey) > 0 {
+		mac := hmac.New(sha256.New, hmacKey)
+		mac.Write(payload)
+		req.Header.Set(NotifySignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := n.HttpClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return 0, true, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return resp.StatusCode, retry, fmt.Errorf("notification target returned status-code: %d", resp.StatusCode)
+}
+
+// pruneNotifications drops the status.notifications of targets no longer in spec.notify
+func pruneNotifications(weather *weatherv1.Weather) {
+	previous := map[string]weatherv1.NotificationStatus{}
+	for _, status := range weather.Status.Notifications {
+		previous[status.Name] = status
+	}
+	var notifications []weatherv1.NotificationStatus
+	for _, target := range weather.Spec.Notify {
+		if status, ok := previous[target.Name]; ok {
+			notifications = append(notifications, status)
+		}
+	}
+	weather.Status.Notifications = notifications
+}
+
+// notify queues a notification of the changed measurements to every spec.notify target. It is called once
+// the refreshed status is written, so a failed write does not notify the same change twice. Deliveries, and
+// failures to queue one, are recorded in status.notifications by the next reconcile, through
+// recordNotifications. They never fail the refresh.
+func (r *WeatherReconciler) notify(ctx context.Context, weather *weatherv1.Weather, changed []string) {
+	if r.Notifier == nil || len(weather.Spec.Notify) == 0 || len(changed) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(NotificationPayload{
+		Namespace:    weather.Namespace,
+		Name:         weather.Name,
+		LocationName: weather.Status.LocationName,
+		Changed:      changed,
+		RefreshTime:  weather.Status.RefreshTime,
+		Units:        weather.Status.Units,
+		Temp:         weather.Status.Temp,
+		Pressure:     weather.Status.Pressure,
+		Humidity:     weather.Status.Humidity,
+		WindSpeed:    weather.Status.WindSpeed,
+		WindGust:     weather.Status.WindGust,
+	})
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Unable to build notification payload")
+		return
+	}
+
+	key := client.ObjectKeyFromObject(weather)
+	for _, target := range weather.Spec.Notify {
+		targetUrl, hmacKey, err := r.notifyTargetSecrets(ctx, weather, target)
+		if err == nil {
+			job := notifyJob{weather: key, target: target.Name, url: targetUrl, hmacKey: hmacKey, payload: payload}
+			if !r.Notifier.enqueue(job) {
+				err = errors.New("the notification queue is full")
+			}
+		}
+		if err != nil {
+			r.Notifier.record(key, target.Name, notifyOutcome{attemptTime: time.Now(), err: err})
+		}
+	}
+}
+
+// recordNotifications records the outcomes of the deliveries finished since the last reconcile in
+// status.notifications, reporting whether there were any
+func (r *WeatherReconciler) recordNotifications(ctx context.Context, weather *weatherv1.Weather) bool {
+	if r.Notifier == nil {
+		return false
+	}
+	outcomes := r.Notifier.takeOutcomes(client.ObjectKeyFromObject(weather))
+	recorded := false
+	for _, target := range weather.Spec.Notify {
+		if outcome, ok := outcomes[target.Name]; ok {
+			r.recordNotification(ctx, weather, target.Name, outcome)
+			recorded = true
+		}
+	}
+	return recorded
+}
+
+// recordNotification records the outcome of a delivery in the status of its target. A failure is logged
+// and emits a Warning event.
+func (r *WeatherReconciler) recordNotification(ctx context.Context, weather *weatherv1.Weather, targetName string, outcome notifyOutcome) {
+	var status *weatherv1.NotificationStatus
+	for i := range weather.Status.Notifications {
+		if weather.Status.Notifications[i].Name == targetName {
+			status = &weather.Status.Notifications[i]
+		}
+	}
+	if status == nil {
+		weather.Status.Notifications = append(weather.Status.Notifications, weatherv1.NotificationStatus{Name: targetName})
+		status = &weather.Status.Notifications[len(weather.Status.Notifications)-1]
+	}
+
+	attemptTime := metav1.NewTime(outcome.attemptTime)
+	status.LastAttemptTime = &attemptTime
+	status.Attempts = int32(outcome.attempts)
+	status.StatusCode = int32(outcome.statusCode)
+	if !outcome.successTime.IsZero() {
+		successTime := metav1.NewTime(outcome.successTime)
+		status.LastSuccessTime = &successTime
+	}
+	if outcome.err != nil {
+		status.Error = outcome.err.Error()
+		errMsg := fmt.Sprintf("Unable to notify '%s': %s", targetName, status.Error)
+		log.FromContext(ctx).Error(outcome.err, "Unable to notify", "target", targetName)
+		r.Recorder.Event(weather, corev1.EventTypeWarning, "Notify", errMsg)
+	} else {
+		status.Error = ""
+	}
+}
+
+// notifyTargetSecrets reads the URL, and the HMAC key when set, of a notify target from its secrets
+func (r *WeatherReconciler) notifyTargetSecrets(ctx context.Context, weather *weatherv1.Weather, target weatherv1.NotifyTarget) (string, []byte, error) {
+	targetUrl, err := r.secretValue(ctx, weather.Namespace, target.URLSecretRef)
+	if err != nil {
+		return "", nil, err
+	}
+	var hmacKey []byte
+	if target.HMACSecretRef != nil {
+		hmacKey, err = r.secretValue(ctx, weather.Namespace, *target.HMACSecretRef)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return strings.TrimSpace(string(targetUrl)), hmacKey, nil
+}
+
+// secretValue reads a key of a secret
+func (r *WeatherReconciler) secretValue(ctx context.Context, namespace string, ref weatherv1.SecretKeyRef) ([]byte, error) {
+	secret := &corev1.Secret{}
+	err := r.Client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: ref.Name}, secret)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find secret '%s'", ref.Name)
+	}
+	value, ok := secret.Data[ref.Key]
+	if !ok {
+		return nil, fmt.Errorf("secret '%s' does not have a '%s' attribute", ref.Name, ref.Key)
+	}
+	return value, nil
+}
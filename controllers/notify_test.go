@@ -0,0 +1,291 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/json"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	weatherv1 "alsup/api/v1"
+)
+
+// notification is a request received by the test receiver
+type notification struct {
+	payload   NotificationPayload
+	signature string
+}
+
+// conflictingStatusClient fails every status update with a conflict, as when the weather changed meanwhile
+type conflictingStatusClient struct {
+	client.Client
+}
+
+func (c conflictingStatusClient) Status() client.StatusWriter {
+	return conflictingStatusWriter{c.Client.Status()}
+}
+
+type conflictingStatusWriter struct {
+	client.StatusWriter
+}
+
+func (w conflictingStatusWriter) Update(_ context.Context, obj client.Object, _ ...client.UpdateOption) error {
+	return apierrors.NewConflict(schema.GroupResource{Group: "weather.alsup", Resource: "weathers"}, obj.GetName(), nil)
+}
+
+var _ = Describe("Weather notifications", func() {
+	var (
+		ctx       context.Context
+		provider  *fakeProvider
+		key       types.NamespacedName
+		weather   *weatherv1.Weather
+		server    *httptest.Server
+		mu        sync.Mutex
+		received  []notification
+		responses []int
+		block     chan struct{}
+		notifier  *Notifier
+		stop      context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "sample"}
+		provider = &fakeProvider{obs: Observation{Time: time.Unix(1650000000, 0), LocationName: "Culpeper", Temp: 61.5}}
+		received = nil
+		responses = nil
+		block = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			wait := block
+			mu.Unlock()
+			if wait != nil {
+				select {
+				case <-wait:
+				case <-r.Context().Done():
+					return
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			body, _ := io.ReadAll(r.Body)
+			n := notification{signature: r.Header.Get(NotifySignatureHeader)}
+			Expect(json.Unmarshal(body, &n.payload)).To(Succeed())
+			received = append(received, n)
+			// sign the raw body, so the test can check the signature
+			mac := hmac.New(sha256.New, []byte("hmac-key"))
+			mac.Write(body)
+			if n.signature != "" {
+				Expect(n.signature).To(Equal("sha256=" + hex.EncodeToString(mac.Sum(nil))))
+			}
+			status := http.StatusNoContent
+			if len(responses) > 0 {
+				status, responses = responses[0], responses[1:]
+			}
+			w.WriteHeader(status)
+		}))
+		weather = newTestWeather()
+		weather.Spec.Notify = []weatherv1.NotifyTarget{{
+			Name:          "chat",
+			URLSecretRef:  weatherv1.SecretKeyRef{Name: "notify-secret", Key: "url"},
+			HMACSecretRef: &weatherv1.SecretKeyRef{Name: "notify-secret", Key: "hmac"},
+		}}
+		notifier = NewNotifier(DefaultNotifyQueueSize)
+		notifier.HttpClient = server.Client()
+		notifier.Backoff = time.Millisecond
+		var workerCtx context.Context
+		workerCtx, stop = context.WithCancel(ctx)
+		go func(n *Notifier) { _ = n.Start(workerCtx) }(notifier)
+	})
+
+	AfterEach(func() {
+		stop()
+		if block != nil {
+			close(block)
+		}
+		server.Close()
+		forgetWeatherMetrics(key)
+	})
+
+	notifySecret := func() *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "notify-secret", Namespace: "default"},
+			Data: map[string][]byte{
+				"url":  []byte(server.URL + "/hooks/secret-path\n"),
+				"hmac": []byte("hmac-key"),
+			},
+		}
+	}
+
+	// refresh reconciles the weather once, which queues the notifications
+	refresh := func() (*WeatherReconciler, chan string) {
+		r, recorder := newTestReconciler(provider, weather, newTestSecret(), notifySecret())
+		r.Notifier = notifier
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		return r, recorder.Events
+	}
+
+	// reconcile refreshes the weather, then waits for the delivery and reconciles again to record it
+	reconcile := func() (*weatherv1.Weather, chan string) {
+		r, events := refresh()
+		Eventually(notifier.Events()).Should(Receive())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		return weather, events
+	}
+
+	It("posts a signed payload of the changed measurements", func() {
+		weather, _ = reconcile()
+		Expect(received).To(HaveLen(1))
+		payload := received[0].payload
+		Expect(payload.Namespace).To(Equal("default"))
+		Expect(payload.Name).To(Equal("sample"))
+		Expect(payload.LocationName).To(Equal("Culpeper"))
+		Expect(payload.Changed).To(ContainElement("Temp"))
+		Expect(payload.Temp).To(Equal(&weatherv1.Measurement{Value: 61.5, Unit: "degF"}))
+		Expect(received[0].signature).To(HavePrefix("sha256="))
+
+		Expect(weather.Status.Notifications).To(HaveLen(1))
+		status := weather.Status.Notifications[0]
+		Expect(status.Name).To(Equal("chat"))
+		Expect(status.Attempts).To(Equal(int32(1)))
+		Expect(status.StatusCode).To(Equal(int32(http.StatusNoContent)))
+		Expect(status.LastSuccessTime).NotTo(BeNil())
+		Expect(status.Error).To(BeEmpty())
+	})
+
+	It("retries server errors with backoff", func() {
+		responses = []int{http.StatusBadGateway, http.StatusServiceUnavailable}
+		weather, _ = reconcile()
+		Expect(received).To(HaveLen(3))
+		Expect(weather.Status.Notifications[0].Attempts).To(Equal(int32(3)))
+		Expect(weather.Status.Notifications[0].Error).To(BeEmpty())
+	})
+
+	It("records failed deliveries without retrying client errors", func() {
+		responses = []int{http.StatusBadRequest}
+		var events chan string
+		weather, events = reconcile()
+		Expect(received).To(HaveLen(1))
+		status := weather.Status.Notifications[0]
+		Expect(status.StatusCode).To(Equal(int32(http.StatusBadRequest)))
+		Expect(status.Error).To(ContainSubstring("400"))
+		Expect(status.LastSuccessTime).To(BeNil())
+		Eventually(events).Should(Receive(HavePrefix("Warning Notify Unable to notify 'chat'")))
+	})
+
+	It("does not reveal the target URL in errors", func() {
+		server.Close()
+		weather, _ = reconcile()
+		Expect(weather.Status.Notifications[0].Attempts).To(Equal(int32(3)))
+		Expect(weather.Status.Notifications[0].Error).NotTo(BeEmpty())
+		Expect(weather.Status.Notifications[0].Error).NotTo(ContainSubstring("secret-path"))
+	})
+
+	It("only notifies when the measurements changed", func() {
+		weather.Status.Units = UnitsImperial
+		weather.Status.Temp = &weatherv1.Measurement{Value: 61.5, Unit: "degF"}
+		weather.Status.Pressure = &weatherv1.Measurement{Value: 0, Unit: "hPa"}
+		weather.Status.Humidity = &weatherv1.Measurement{Value: 0, Unit: "%"}
+		weather.Status.WindSpeed = &weatherv1.Measurement{Value: 0, Unit: "mph"}
+		weather.Status.WindGust = &weatherv1.Measurement{Value: 0, Unit: "mph"}
+		r, _ := refresh()
+		Consistently(notifier.Events(), 50*time.Millisecond).ShouldNot(Receive())
+		Expect(received).To(BeEmpty())
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Notifications).To(BeEmpty())
+	})
+
+	It("does not hold up the reconcile while a target is slow", func() {
+		mu.Lock()
+		block = make(chan struct{})
+		mu.Unlock()
+		notifier.Timeout = 100 * time.Millisecond
+		notifier.Attempts = 1
+		r, _ := refresh()
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Notifications).To(BeEmpty())
+		Expect(weather.Status.Temp).NotTo(BeNil())
+
+		Eventually(notifier.Events()).Should(Receive())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Notifications[0].Error).To(ContainSubstring("deadline exceeded"))
+	})
+
+	It("notifies and reports the changes only once the status is written", func() {
+		weather.Spec.Thresholds = []weatherv1.Threshold{{Name: "warm", Measurement: weatherv1.MeasurementTemp, Operator: weatherv1.ThresholdAbove, Value: 50}}
+		r, recorder := newTestReconciler(provider, weather, newTestSecret(), notifySecret())
+		r.Notifier = notifier
+		c := r.Client
+		r.Client = conflictingStatusClient{c}
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(apierrors.IsConflict(err)).To(BeTrue())
+		Consistently(notifier.Events(), 100*time.Millisecond).ShouldNot(Receive())
+		Expect(recorder.Events).To(BeEmpty())
+
+		r.Client = c
+		_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(notifier.Events()).Should(Receive())
+		Expect(received).To(HaveLen(1))
+		Expect(recorder.Events).To(Receive(ContainSubstring("Warning ThresholdBreached Threshold 'warm' breached")))
+		Expect(recorder.Events).To(Receive(ContainSubstring("Normal Updated Weather changed.")))
+	})
+
+	It("forgets the delivery outcomes of a deleted weather", func() {
+		r, _ := refresh()
+		Eventually(notifier.Events()).Should(Receive())
+		Expect(r.Client.Delete(ctx, weather)).To(Succeed())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(notifier.takeOutcomes(key)).To(BeEmpty())
+	})
+
+	It("records a failure when the delivery queue is full", func() {
+		notifier = NewNotifier(0)
+		var events chan string
+		var r *WeatherReconciler
+		r, events = refresh()
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Notifications).To(HaveLen(1))
+		Expect(weather.Status.Notifications[0].Error).To(Equal("the notification queue is full"))
+		Eventually(events).Should(Receive(Equal("Warning Notify Unable to notify 'chat': the notification queue is full")))
+	})
+})
@@ -0,0 +1,314 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	weatherv1 "alsup/api/v1"
+)
+
+const DefaultProvider = weatherv1.DefaultProvider
+
+// DefaultRetryAfter is how long to wait after a provider rate limited a call without a usable Retry-After header
+const DefaultRetryAfter = time.Minute
+
+// ObservationRequest describes the location (and credentials) a WeatherProvider should query
+type ObservationRequest struct {
+	Lat string
+	Lon string
+	// Units is the unit system (UnitsImperial, UnitsMetric or UnitsStandard) measurements are returned in
+	Units string
+	Token string
+}
+
+// Observation is a provider-neutral snapshot of the current weather conditions
+type Observation struct {
+	Time         time.Time
+	CountryCode  string
+	LocationName string
+	Temp         float64
+	// Pressure, Humidity, WindSpeed and WindGust are nil when the station did not report them, keeping the
+	// previous reading
+	Pressure  *int64
+	Humidity  *int64
+	WindSpeed *float64
+	WindGust  *float64
+
+	// The remaining fields are only reported by some providers, and are nil or zero otherwise
+	FeelsLike *float64
+	TempMin   *float64
+	TempMax   *float64
+	// Visibility is in meters
+	Visibility *float64
+	// WindDeg is the direction the wind blows from, in degrees clockwise from north
+	WindDeg *float64
+	// CloudCover is in percent
+	CloudCover *float64
+	Condition  *Condition
+	Sunrise    time.Time
+	Sunset     time.Time
+	// Rain and Snow are precipitation volumes in mm
+	Rain *PrecipitationVolume
+	Snow *PrecipitationVolume
+}
+
+// Condition is a provider's summary of the current conditions
+type Condition struct {
+	Id          int32
+	Main        string
+	Description string
+	Icon        string
+}
+
+// PrecipitationVolume is the precipitation of the last 1 and 3 hours, in mm; nil when not reported
+type PrecipitationVolume struct {
+	LastHour   *float64
+	Last3Hours *float64
+}
+
+// WeatherProvider fetches current conditions from an upstream weather service
+type WeatherProvider interface {
+	// Name is the value used to select this provider in spec.provider
+	Name() string
+	// RequiresToken reports whether an API token must be read from spec.secretRef
+	RequiresToken() bool
+	// CurrentConditions fetches the current conditions for the requested coordinates
+	CurrentConditions(ctx context.Context, req ObservationRequest) (*Observation, error)
+}
+
+// ForecastPeriod is a provider-neutral forecast for the period starting at Time
+type ForecastPeriod struct {
+	Time time.Time
+	Temp float64
+	// PrecipitationProbability is in percent
+	PrecipitationProbability float64
+	Summary                  string
+}
+
+// ForecastProvider is implemented by WeatherProviders that can also fetch a forecast
+type ForecastProvider interface {
+	// Forecast fetches up to periods upcoming forecast periods for the requested coordinates, in order
+	Forecast(ctx context.Context, req ObservationRequest, periods int) ([]ForecastPeriod, error)
+}
+
+// Alert is a provider-neutral severe weather alert
+type Alert struct {
+	// Id identifies the alert across calls
+	Id       string
+	Event    string
+	Headline string
+	Severity string
+	Sender   string
+	// Start and End are zero when the provider does not report them
+	Start time.Time
+	End   time.Time
+}
+
+// AlertProvider is implemented by WeatherProviders that can also fetch active severe weather alerts
+type AlertProvider interface {
+	// Alerts fetches the alerts currently active for the requested coordinates
+	Alerts(ctx context.Context, req ObservationRequest) ([]Alert, error)
+}
+
+// AirQuality is a provider-neutral air pollution observation
+type AirQuality struct {
+	Time time.Time
+	// Index is the air quality index, from 1 (good) to 5 (very poor)
+	Index int
+	// Pollutant concentrations, in µg/m³
+	CO   float64
+	NO2  float64
+	O3   float64
+	SO2  float64
+	PM25 float64
+	PM10 float64
+}
+
+// AirQualityProvider is implemented by WeatherProviders that can also fetch the air pollution
+type AirQualityProvider interface {
+	// AirQuality fetches the current air pollution for the requested coordinates
+	AirQuality(ctx context.Context, req ObservationRequest) (*AirQuality, error)
+}
+
+// GeocodeRequest describes a place to resolve into coordinates, by city or postal code
+type GeocodeRequest struct {
+	City        string
+	CountryCode string
+	Zip         string
+	Token       string
+}
+
+// Location is a place resolved by a Geocoder
+type Location struct {
+	Lat  float64
+	Lon  float64
+	Name string
+}
+
+// Geocoder is implemented by WeatherProviders that can resolve a place into coordinates
+type Geocoder interface {
+	// Geocode resolves the requested place, returning a *LocationNotFoundError when there is no match
+	Geocode(ctx context.Context, req GeocodeRequest) (*Location, error)
+}
+
+// LocationNotFoundError is returned by a Geocoder when the requested place does not match any location
+type LocationNotFoundError struct {
+	Query string
+}
+
+func (e *LocationNotFoundError) Error() string {
+	return fmt.Sprintf("location '%s' not found", e.Query)
+}
+
+// geocodeQuery formats a GeocodeRequest for messages, as "city,country" or "zip,country"
+func geocodeQuery(req GeocodeRequest) string {
+	query := req.City
+	if len(req.Zip) > 0 {
+		query = req.Zip
+	}
+	if len(req.CountryCode) > 0 {
+		query += "," + req.CountryCode
+	}
+	return query
+}
+
+// DefaultProviders returns the built-in providers, keyed by name
+func DefaultProviders() map[string]WeatherProvider {
+	providers := map[string]WeatherProvider{}
+	for _, p := range []WeatherProvider{
+		NewOpenWeatherMapProvider(),
+		NewNWSProvider(),
+		NewOpenMeteoProvider(),
+	} {
+		providers[p.Name()] = p
+	}
+	return providers
+}
+
+// RateLimitedError is returned when a provider call was throttled, either by the provider (HTTP 429)
+// or by the operator's own RateLimiter
+type RateLimitedError struct {
+	Provider   string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("provider '%s' rate limit reached, retry after %s", e.Provider, e.RetryAfter)
+}
+
+// ErrorKind classifies a ProviderError by how Reconcile handles it
+type ErrorKind string
+
+const (
+	// ErrorAuth means the provider rejected the API token; retrying cannot succeed until the secret changes
+	ErrorAuth ErrorKind = "Auth"
+	// ErrorNotFound means the provider rejected or does not cover the requested location; retrying cannot
+	// succeed until the spec changes
+	ErrorNotFound ErrorKind = "NotFound"
+	// ErrorTransient means the call may succeed when retried: network errors, timeouts and 5xx responses
+	ErrorTransient ErrorKind = "Transient"
+	// ErrorDecode means the provider response could not be parsed
+	ErrorDecode ErrorKind = "Decode"
+)
+
+// ProviderError is a failed provider call, classified by Kind. Throttled calls are reported as a
+// RateLimitedError instead.
+type ProviderError struct {
+	Provider string
+	Kind     ErrorKind
+	// StatusCode is the HTTP status of the response, 0 when no response was received
+	StatusCode int
+	Err        error
+}
+
+func (e *ProviderError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("provider '%s' returned status-code: %d", e.Provider, e.StatusCode)
+	}
+	return fmt.Sprintf("provider '%s': %s", e.Provider, e.Err)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// statusCodeError classifies an unexpected HTTP status code
+func statusCodeError(provider string, statusCode int) *ProviderError {
+	kind := ErrorTransient
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		kind = ErrorAuth
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusGone, http.StatusUnprocessableEntity:
+		kind = ErrorNotFound
+	}
+	return &ProviderError{Provider: provider, Kind: kind, StatusCode: statusCode}
+}
+
+// transientError wraps a network error, or a failure to read a response, as a transient ProviderError.
+// Network errors are *url.Errors quoting the request URL, so tokens in its query are redacted.
+func transientError(provider string, err error) *ProviderError {
+	return &ProviderError{Provider: provider, Kind: ErrorTransient, Err: redact(err)}
+}
+
+// decodeError wraps a response parse failure as a ProviderError and counts it
+func decodeError(provider string, err error) *ProviderError {
+	providerParseFailures.WithLabelValues(provider).Inc()
+	return &ProviderError{Provider: provider, Kind: ErrorDecode, Err: err}
+}
+
+// errorKind returns the kind of a provider error; errors not classified by the provider are assumed transient
+func errorKind(err error) ErrorKind {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr.Kind
+	}
+	return ErrorTransient
+}
+
+// rateLimitedResponse returns the RateLimitedError for an HTTP 429 response, honoring its Retry-After header
+func rateLimitedResponse(provider string, resp *http.Response) *RateLimitedError {
+	return &RateLimitedError{Provider: provider, RetryAfter: retryAfter(resp.Header.Get("Retry-After"), time.Now())}
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as an HTTP date. The delay is at least a second,
+// since a zero RequeueAfter would not requeue at all.
+func retryAfter(header string, now time.Time) time.Duration {
+	delay := DefaultRetryAfter
+	if secs, err := strconv.Atoi(header); err == nil && secs >= 0 {
+		delay = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(header); err == nil {
+		delay = t.Sub(now)
+	}
+	if delay < time.Second {
+		delay = time.Second
+	}
+	return delay
+}
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
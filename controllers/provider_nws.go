@@ -0,0 +1,369 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/json"
+
+	weatherv1 "alsup/api/v1"
+)
+
+const NWSUrl = "https://api.weather.gov"
+const NWSUserAgent = "(weather-operator, github.com/aalsup/weather-operator)"
+
+// NWSQuantity is a measured value as reported by api.weather.gov; Value is nil when the station has no reading
+type NWSQuantity struct {
+	Value    *float64 `json:"value"`
+	UnitCode string   `json:"unitCode"`
+}
+
+type NWSPointsResponse struct {
+	Properties struct {
+		ObservationStations string `json:"observationStations"`
+		ForecastHourly      string `json:"forecastHourly"`
+		RelativeLocation    struct {
+			Properties struct {
+				City  string `json:"city"`
+				State string `json:"state"`
+			} `json:"properties"`
+		} `json:"relativeLocation"`
+	} `json:"properties"`
+}
+
+type NWSStationsResponse struct {
+	Features []struct {
+		Properties struct {
+			StationIdentifier string `json:"stationIdentifier"`
+			Name              string `json:"name"`
+		} `json:"properties"`
+	} `json:"features"`
+}
+
+type NWSObservationResponse struct {
+	Properties struct {
+		Station            string      `json:"station"`
+		Timestamp          string      `json:"timestamp"`
+		TextDescription    string      `json:"textDescription"`
+		Temperature        NWSQuantity `json:"temperature"`
+		BarometricPressure NWSQuantity `json:"barometricPressure"`
+		SeaLevelPressure   NWSQuantity `json:"seaLevelPressure"`
+		RelativeHumidity   NWSQuantity `json:"relativeHumidity"`
+		WindSpeed          NWSQuantity `json:"windSpeed"`
+		WindGust           NWSQuantity `json:"windGust"`
+	} `json:"properties"`
+}
+
+type NWSForecastResponse struct {
+	Properties struct {
+		Periods []struct {
+			StartTime                  string      `json:"startTime"`
+			Temperature                float64     `json:"temperature"`
+			TemperatureUnit            string      `json:"temperatureUnit"`
+			ProbabilityOfPrecipitation NWSQuantity `json:"probabilityOfPrecipitation"`
+			ShortForecast              string      `json:"shortForecast"`
+		} `json:"periods"`
+	} `json:"properties"`
+}
+
+type NWSAlertsResponse struct {
+	Features []struct {
+		Properties struct {
+			Id         string `json:"id"`
+			Event      string `json:"event"`
+			Headline   string `json:"headline"`
+			Severity   string `json:"severity"`
+			SenderName string `json:"senderName"`
+			Onset      string `json:"onset"`
+			Effective  string `json:"effective"`
+			Ends       string `json:"ends"`
+			Expires    string `json:"expires"`
+		} `json:"properties"`
+	} `json:"features"`
+}
+
+// nwsStation is the observation station nearest to a coordinate, and the forecast of its grid point
+type nwsStation struct {
+	Id                string
+	LocationName      string
+	ForecastHourlyUrl string
+}
+
+// NWSProvider queries the US National Weather Service API for observations and hourly forecasts, which requires no token
+type NWSProvider struct {
+	BaseUrl    string
+	HttpClient *http.Client
+
+	// stations caches the /points lookup, which never changes for a coordinate
+	mu       sync.Mutex
+	stations map[string]nwsStation
+}
+
+func NewNWSProvider() *NWSProvider {
+	return &NWSProvider{
+		BaseUrl:    NWSUrl,
+		HttpClient: newProviderHttpClient(weatherv1.ProviderNWS),
+		stations:   map[string]nwsStation{},
+	}
+}
+
+func (p *NWSProvider) Name() string {
+	return weatherv1.ProviderNWS
+}
+
+func (p *NWSProvider) RequiresToken() bool {
+	return false
+}
+
+func (p *NWSProvider) CurrentConditions(ctx context.Context, req ObservationRequest) (*Observation, error) {
+	station, err := p.station(ctx, req.Lat, req.Lon)
+	if err != nil {
+		return nil, err
+	}
+
+	var jResponse NWSObservationResponse
+	err = p.get(ctx, fmt.Sprintf("%s/stations/%s/observations/latest", p.BaseUrl, station.Id), &jResponse)
+	if err != nil {
+		return nil, err
+	}
+	props := jResponse.Properties
+
+	obs := &Observation{
+		CountryCode:  "US",
+		LocationName: station.LocationName,
+	}
+	if ts, err := time.Parse(time.RFC3339, props.Timestamp); err == nil {
+		obs.Time = ts
+	}
+	if len(props.TextDescription) > 0 {
+		obs.Condition = &Condition{Main: props.TextDescription}
+	}
+	// stations regularly report null readings; without a temperature the observation is useless
+	v, ok := props.Temperature.value()
+	if !ok {
+		return nil, &ProviderError{Provider: p.Name(), Kind: ErrorTransient, Err: fmt.Errorf("station %s reported no temperature", station.Id)}
+	}
+	obs.Temp = fromCelsius(v, req.Units)
+	pressure := props.SeaLevelPressure
+	if pressure.Value == nil {
+		pressure = props.BarometricPressure
+	}
+	if v, ok := pressure.value(); ok {
+		// Pa -> hPa, to match OpenWeatherMap
+		obs.Pressure = int64Ptr(int64(math.Round(v / 100)))
+	}
+	if v, ok := props.RelativeHumidity.value(); ok {
+		obs.Humidity = int64Ptr(int64(math.Round(v)))
+	}
+	if v, ok := props.WindSpeed.value(); ok {
+		obs.WindSpeed = float64Ptr(fromMetersPerSecond(props.WindSpeed.toMetersPerSecond(v), req.Units))
+	}
+	if v, ok := props.WindGust.value(); ok {
+		obs.WindGust = float64Ptr(fromMetersPerSecond(props.WindGust.toMetersPerSecond(v), req.Units))
+	}
+	return obs, nil
+}
+
+func (p *NWSProvider) Forecast(ctx context.Context, req ObservationRequest, periods int) ([]ForecastPeriod, error) {
+	station, err := p.station(ctx, req.Lat, req.Lon)
+	if err != nil {
+		return nil, err
+	}
+	if len(station.ForecastHourlyUrl) == 0 {
+		return nil, fmt.Errorf("no NWS hourly forecast available near %s,%s", req.Lat, req.Lon)
+	}
+
+	var jResponse NWSForecastResponse
+	err = p.get(ctx, station.ForecastHourlyUrl, &jResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	var forecast []ForecastPeriod
+	for _, item := range jResponse.Properties.Periods {
+		if len(forecast) == periods {
+			break
+		}
+		ts, err := time.Parse(time.RFC3339, item.StartTime)
+		if err != nil {
+			continue
+		}
+		// the hourly forecast is in Fahrenheit, unless requested in SI units
+		celsius := item.Temperature
+		if item.TemperatureUnit == "F" {
+			celsius = (item.Temperature - 32) * 5 / 9
+		}
+		period := ForecastPeriod{
+			Time:    ts,
+			Temp:    fromCelsius(celsius, req.Units),
+			Summary: item.ShortForecast,
+		}
+		if v, ok := item.ProbabilityOfPrecipitation.value(); ok {
+			period.PrecipitationProbability = v
+		}
+		forecast = append(forecast, period)
+	}
+	return forecast, nil
+}
+
+func (p *NWSProvider) Alerts(ctx context.Context, req ObservationRequest) ([]Alert, error) {
+	point, err := nwsPoint(req.Lat, req.Lon)
+	if err != nil {
+		return nil, err
+	}
+	var jResponse NWSAlertsResponse
+	err = p.get(ctx, fmt.Sprintf("%s/alerts/active?point=%s", p.BaseUrl, point), &jResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	var alerts []Alert
+	for _, feature := range jResponse.Features {
+		props := feature.Properties
+		alerts = append(alerts, Alert{
+			Id:       props.Id,
+			Event:    props.Event,
+			Headline: props.Headline,
+			Severity: props.Severity,
+			Sender:   props.SenderName,
+			Start:    parseNWSTime(props.Onset, props.Effective),
+			End:      parseNWSTime(props.Ends, props.Expires),
+		})
+	}
+	return alerts, nil
+}
+
+// station resolves a coordinate to its nearest observation station, via /points
+func (p *NWSProvider) station(ctx context.Context, lat string, lon string) (nwsStation, error) {
+	point, err := nwsPoint(lat, lon)
+	if err != nil {
+		return nwsStation{}, err
+	}
+
+	p.mu.Lock()
+	station, ok := p.stations[point]
+	p.mu.Unlock()
+	if ok {
+		return station, nil
+	}
+
+	var points NWSPointsResponse
+	err = p.get(ctx, fmt.Sprintf("%s/points/%s", p.BaseUrl, point), &points)
+	if err != nil {
+		return nwsStation{}, err
+	}
+	var stations NWSStationsResponse
+	err = p.get(ctx, points.Properties.ObservationStations, &stations)
+	if err != nil {
+		return nwsStation{}, err
+	}
+	if len(stations.Features) == 0 {
+		return nwsStation{}, &ProviderError{Provider: p.Name(), Kind: ErrorNotFound, Err: fmt.Errorf("no NWS observation stations found near %s", point)}
+	}
+	station = nwsStation{
+		Id:                stations.Features[0].Properties.StationIdentifier,
+		LocationName:      points.Properties.RelativeLocation.Properties.City,
+		ForecastHourlyUrl: points.Properties.ForecastHourly,
+	}
+
+	p.mu.Lock()
+	p.stations[point] = station
+	p.mu.Unlock()
+	return station, nil
+}
+
+// get fetches an api.weather.gov resource and parses the JSON response into out
+func (p *NWSProvider) get(ctx context.Context, url string, out interface{}) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	// api.weather.gov rejects requests without a User-Agent identifying the application
+	httpReq.Header.Set("User-Agent", NWSUserAgent)
+	httpReq.Header.Set("Accept", "application/geo+json")
+	resp, err := p.HttpClient.Do(httpReq)
+	if err != nil {
+		return transientError(p.Name(), err)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return rateLimitedResponse(p.Name(), resp)
+	}
+	if resp.StatusCode != 200 {
+		return statusCodeError(p.Name(), resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return transientError(p.Name(), err)
+	}
+	err = json.Unmarshal(data, out)
+	if err != nil {
+		return decodeError(p.Name(), fmt.Errorf("unable to parse NWS JSON response: %w", err))
+	}
+	return nil
+}
+
+// nwsPoint formats a coordinate as an api.weather.gov point
+func nwsPoint(lat string, lon string) (string, error) {
+	fLat, err := strconv.ParseFloat(lat, 64)
+	if err != nil {
+		return "", &ProviderError{Provider: weatherv1.ProviderNWS, Kind: ErrorNotFound, Err: fmt.Errorf("invalid latitude '%s': %w", lat, err)}
+	}
+	fLon, err := strconv.ParseFloat(lon, 64)
+	if err != nil {
+		return "", &ProviderError{Provider: weatherv1.ProviderNWS, Kind: ErrorNotFound, Err: fmt.Errorf("invalid longitude '%s': %w", lon, err)}
+	}
+	// api.weather.gov redirects requests with more than 4 decimal places
+	return fmt.Sprintf("%.4f,%.4f", fLat, fLon), nil
+}
+
+// parseNWSTime parses the first valid RFC 3339 timestamp, returning the zero time when there is none
+func parseNWSTime(values ...string) time.Time {
+	for _, value := range values {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (q NWSQuantity) value() (float64, bool) {
+	if q.Value == nil {
+		return 0, false
+	}
+	return *q.Value, true
+}
+
+// toMetersPerSecond converts a speed reported in km/h or m/s into m/s
+func (q NWSQuantity) toMetersPerSecond(v float64) float64 {
+	switch q.UnitCode {
+	case "wmoUnit:m_s-1":
+		return v
+	default:
+		return v / 3.6
+	}
+}
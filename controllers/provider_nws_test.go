@@ -0,0 +1,218 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const nwsPointsSample = `{
+  "properties": {
+    "observationStations": "%[1]s/gridpoints/LWX/58,52/stations",
+    "forecastHourly": "%[1]s/gridpoints/LWX/58,52/forecast/hourly",
+    "relativeLocation": {"properties": {"city": "Culpeper", "state": "VA"}}
+  }
+}`
+
+const nwsStationsSample = `{
+  "features": [
+    {"properties": {"stationIdentifier": "KCJR", "name": "Culpeper Regional Airport"}},
+    {"properties": {"stationIdentifier": "KHEF", "name": "Manassas Regional Airport"}}
+  ]
+}`
+
+const nwsObservationSample = `{
+  "properties": {
+    "station": "https://api.weather.gov/stations/KCJR",
+    "timestamp": "2022-04-15T05:20:00+00:00",
+    "textDescription": "Clear",
+    "temperature": {"unitCode": "wmoUnit:degC", "value": 20},
+    "barometricPressure": {"unitCode": "wmoUnit:Pa", "value": 101000},
+    "seaLevelPressure": {"unitCode": "wmoUnit:Pa", "value": 101520},
+    "relativeHumidity": {"unitCode": "wmoUnit:percent", "value": 40.6},
+    "windSpeed": {"unitCode": "wmoUnit:km_h-1", "value": 16.09344},
+    "windGust": {"unitCode": "wmoUnit:km_h-1", "value": null}
+  }
+}`
+
+// nwsNullObservationSample is an observation of a station whose sensors reported nothing
+const nwsNullObservationSample = `{
+  "properties": {
+    "station": "https://api.weather.gov/stations/KCJR",
+    "timestamp": "2022-04-15T05:20:00+00:00",
+    "textDescription": "",
+    "temperature": {"unitCode": "wmoUnit:degC", "value": null},
+    "barometricPressure": {"unitCode": "wmoUnit:Pa", "value": null},
+    "seaLevelPressure": {"unitCode": "wmoUnit:Pa", "value": null},
+    "relativeHumidity": {"unitCode": "wmoUnit:percent", "value": null},
+    "windSpeed": {"unitCode": "wmoUnit:km_h-1", "value": null},
+    "windGust": {"unitCode": "wmoUnit:km_h-1", "value": null}
+  }
+}`
+
+const nwsForecastSample = `{
+  "properties": {
+    "periods": [
+      {"number": 1, "startTime": "2022-04-15T06:00:00-04:00", "temperature": 59, "temperatureUnit": "F",
+       "probabilityOfPrecipitation": {"unitCode": "wmoUnit:percent", "value": 10}, "shortForecast": "Mostly Clear"},
+      {"number": 2, "startTime": "2022-04-15T07:00:00-04:00", "temperature": 57, "temperatureUnit": "F",
+       "probabilityOfPrecipitation": {"unitCode": "wmoUnit:percent", "value": null}, "shortForecast": "Patchy Fog"},
+      {"number": 3, "startTime": "2022-04-15T08:00:00-04:00", "temperature": 60, "temperatureUnit": "F",
+       "probabilityOfPrecipitation": {"unitCode": "wmoUnit:percent", "value": 20}, "shortForecast": "Sunny"}
+    ]
+  }
+}`
+
+const nwsAlertsSample = `{
+  "features": [
+    {"properties": {
+      "id": "urn:oid:2.49.0.1.840.0.abc",
+      "event": "Severe Thunderstorm Warning",
+      "headline": "Severe Thunderstorm Warning issued April 15 at 5:12PM EDT until April 15 at 6:00PM EDT by NWS Sterling VA",
+      "severity": "Severe",
+      "senderName": "NWS Sterling VA",
+      "onset": null,
+      "effective": "2022-04-15T17:12:00-04:00",
+      "ends": "2022-04-15T18:00:00-04:00",
+      "expires": "2022-04-15T18:00:00-04:00"
+    }}
+  ]
+}`
+
+var _ = Describe("NWSProvider", func() {
+	var (
+		server      *httptest.Server
+		requests    map[string]int
+		observation string
+	)
+
+	BeforeEach(func() {
+		requests = map[string]int{}
+		observation = nwsObservationSample
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests[r.URL.Path]++
+			Expect(r.Header.Get("User-Agent")).NotTo(BeEmpty())
+			switch r.URL.Path {
+			case "/points/38.4465,-77.9883":
+				_, _ = fmt.Fprintf(w, nwsPointsSample, server.URL)
+			case "/gridpoints/LWX/58,52/stations":
+				_, _ = w.Write([]byte(nwsStationsSample))
+			case "/stations/KCJR/observations/latest":
+				_, _ = w.Write([]byte(observation))
+			case "/alerts/active":
+				Expect(r.URL.Query().Get("point")).To(Equal("38.4465,-77.9883"))
+				_, _ = w.Write([]byte(nwsAlertsSample))
+			case "/gridpoints/LWX/58,52/forecast/hourly":
+				_, _ = w.Write([]byte(nwsForecastSample))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newProvider := func() *NWSProvider {
+		p := NewNWSProvider()
+		p.BaseUrl = server.URL
+		return p
+	}
+
+	It("resolves the nearest station and maps its latest observation", func() {
+		obs, err := newProvider().CurrentConditions(context.Background(), ObservationRequest{Lat: "38.446507669062406", Lon: "-77.98832108933742", Units: UnitsImperial})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obs.Temp).To(BeNumerically("~", 68, 0.001))
+		Expect(*obs.Pressure).To(Equal(int64(1015)))
+		Expect(*obs.Humidity).To(Equal(int64(41)))
+		Expect(*obs.WindSpeed).To(BeNumerically("~", 10, 0.001))
+		Expect(obs.WindGust).To(BeNil())
+		Expect(obs.LocationName).To(Equal("Culpeper"))
+		Expect(obs.CountryCode).To(Equal("US"))
+		Expect(obs.Time.UTC().Format("15:04")).To(Equal("05:20"))
+		Expect(obs.Condition).To(Equal(&Condition{Main: "Clear"}))
+	})
+
+	It("rejects an observation without a temperature and leaves other null readings unset", func() {
+		observation = nwsNullObservationSample
+		_, err := newProvider().CurrentConditions(context.Background(), ObservationRequest{Lat: "38.4465", Lon: "-77.9883"})
+		Expect(err).To(MatchError(ContainSubstring("station KCJR reported no temperature")))
+		Expect(errorKind(err)).To(Equal(ErrorTransient))
+
+		observation = strings.Replace(nwsNullObservationSample, `degC", "value": null`, `degC", "value": 20`, 1)
+		obs, err := newProvider().CurrentConditions(context.Background(), ObservationRequest{Lat: "38.4465", Lon: "-77.9883"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obs.Pressure).To(BeNil())
+		Expect(obs.Humidity).To(BeNil())
+		Expect(obs.WindSpeed).To(BeNil())
+		Expect(obs.WindGust).To(BeNil())
+	})
+
+	It("converts to the requested unit system", func() {
+		obs, err := newProvider().CurrentConditions(context.Background(), ObservationRequest{Lat: "38.4465", Lon: "-77.9883", Units: UnitsMetric})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obs.Temp).To(BeNumerically("~", 20, 0.001))
+		Expect(*obs.WindSpeed).To(BeNumerically("~", 4.4704, 0.001))
+	})
+
+	It("only looks up the station once per coordinate", func() {
+		p := newProvider()
+		req := ObservationRequest{Lat: "38.4465", Lon: "-77.9883"}
+		_, err := p.CurrentConditions(context.Background(), req)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = p.CurrentConditions(context.Background(), req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requests).To(HaveKeyWithValue("/points/38.4465,-77.9883", 1))
+		Expect(requests).To(HaveKeyWithValue("/stations/KCJR/observations/latest", 2))
+	})
+
+	It("returns an error for coordinates outside NWS coverage", func() {
+		_, err := newProvider().CurrentConditions(context.Background(), ObservationRequest{Lat: "51.5", Lon: "-0.12"})
+		Expect(err).To(MatchError(ContainSubstring("404")))
+		Expect(errorKind(err)).To(Equal(ErrorNotFound))
+	})
+
+	It("maps the hourly forecast of the grid point into forecast periods", func() {
+		forecast, err := newProvider().Forecast(context.Background(), ObservationRequest{Lat: "38.4465", Lon: "-77.9883", Units: UnitsMetric}, 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(forecast).To(HaveLen(2))
+		Expect(forecast[0].Time.UTC().Format("15:04")).To(Equal("10:00"))
+		Expect(forecast[0].Temp).To(BeNumerically("~", 15, 0.001))
+		Expect(forecast[0].PrecipitationProbability).To(Equal(10.0))
+		Expect(forecast[0].Summary).To(Equal("Mostly Clear"))
+		Expect(forecast[1].PrecipitationProbability).To(BeZero())
+	})
+
+	It("maps the active alerts for the point", func() {
+		alerts, err := newProvider().Alerts(context.Background(), ObservationRequest{Lat: "38.446507669062406", Lon: "-77.98832108933742"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(alerts).To(HaveLen(1))
+		Expect(alerts[0].Id).To(Equal("urn:oid:2.49.0.1.840.0.abc"))
+		Expect(alerts[0].Event).To(Equal("Severe Thunderstorm Warning"))
+		Expect(alerts[0].Severity).To(Equal("Severe"))
+		Expect(alerts[0].Start.UTC().Format("15:04")).To(Equal("21:12"))
+		Expect(alerts[0].End.UTC().Format("15:04")).To(Equal("22:00"))
+	})
+})
@@ -0,0 +1,228 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/json"
+
+	weatherv1 "alsup/api/v1"
+)
+
+const OpenMeteoUrl = "https://api.open-meteo.com/v1/forecast"
+const OpenMeteoGeocodingUrl = "https://geocoding-api.open-meteo.com/v1/search"
+
+// OpenMeteoCurrentVariables are the `current` variables requested from Open-Meteo
+const OpenMeteoCurrentVariables = "temperature_2m,relative_humidity_2m,pressure_msl,wind_speed_10m,wind_gusts_10m"
+
+// OpenMeteoHourlyVariables are the `hourly` variables requested from Open-Meteo for forecasts
+const OpenMeteoHourlyVariables = "temperature_2m,precipitation_probability"
+
+type OpenMeteoResponse struct {
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+	Timezone     string  `json:"timezone"`
+	CurrentUnits struct {
+		Temperature      string `json:"temperature_2m"`
+		RelativeHumidity string `json:"relative_humidity_2m"`
+		PressureMsl      string `json:"pressure_msl"`
+		WindSpeed        string `json:"wind_speed_10m"`
+		WindGusts        string `json:"wind_gusts_10m"`
+	} `json:"current_units"`
+	Current struct {
+		Time             int64   `json:"time"`
+		Interval         int64   `json:"interval"`
+		Temperature      float64 `json:"temperature_2m"`
+		RelativeHumidity float64 `json:"relative_humidity_2m"`
+		PressureMsl      float64 `json:"pressure_msl"`
+		WindSpeed        float64 `json:"wind_speed_10m"`
+		WindGusts        float64 `json:"wind_gusts_10m"`
+	} `json:"current"`
+	Hourly struct {
+		Time                     []int64   `json:"time"`
+		Temperature              []float64 `json:"temperature_2m"`
+		PrecipitationProbability []float64 `json:"precipitation_probability"`
+	} `json:"hourly"`
+}
+
+type OpenMeteoGeocodingResponse struct {
+	Results []struct {
+		Name        string  `json:"name"`
+		Latitude    float64 `json:"latitude"`
+		Longitude   float64 `json:"longitude"`
+		CountryCode string  `json:"country_code"`
+	} `json:"results"`
+}
+
+// OpenMeteoProvider queries the Open-Meteo forecast API for current conditions and hourly forecasts, and its
+// geocoding API for locations, neither of which requires a token
+type OpenMeteoProvider struct {
+	BaseUrl      string
+	GeocodingUrl string
+	HttpClient   *http.Client
+}
+
+func NewOpenMeteoProvider() *OpenMeteoProvider {
+	return &OpenMeteoProvider{
+		BaseUrl:      OpenMeteoUrl,
+		GeocodingUrl: OpenMeteoGeocodingUrl,
+		HttpClient:   newProviderHttpClient(weatherv1.ProviderOpenMeteo),
+	}
+}
+
+func (p *OpenMeteoProvider) Name() string {
+	return weatherv1.ProviderOpenMeteo
+}
+
+func (p *OpenMeteoProvider) RequiresToken() bool {
+	return false
+}
+
+func (p *OpenMeteoProvider) CurrentConditions(ctx context.Context, req ObservationRequest) (*Observation, error) {
+	query := p.query(req)
+	query.Set("current", OpenMeteoCurrentVariables)
+	var jResponse OpenMeteoResponse
+	err := p.get(ctx, p.BaseUrl, query, &jResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	temp := jResponse.Current.Temperature
+	if req.Units == UnitsStandard {
+		temp = fromCelsius(temp, UnitsStandard)
+	}
+
+	// Open-Meteo is a gridded model, so there is no named location or country to report
+	return &Observation{
+		Time:      time.Unix(jResponse.Current.Time, 0),
+		Temp:      temp,
+		Pressure:  int64Ptr(int64(math.Round(jResponse.Current.PressureMsl))),
+		Humidity:  int64Ptr(int64(math.Round(jResponse.Current.RelativeHumidity))),
+		WindSpeed: &jResponse.Current.WindSpeed,
+		WindGust:  &jResponse.Current.WindGusts,
+	}, nil
+}
+
+func (p *OpenMeteoProvider) Forecast(ctx context.Context, req ObservationRequest, periods int) ([]ForecastPeriod, error) {
+	query := p.query(req)
+	query.Set("hourly", OpenMeteoHourlyVariables)
+	query.Set("forecast_hours", strconv.Itoa(periods))
+	var jResponse OpenMeteoResponse
+	err := p.get(ctx, p.BaseUrl, query, &jResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	hourly := jResponse.Hourly
+	if len(hourly.Temperature) < len(hourly.Time) || len(hourly.PrecipitationProbability) < len(hourly.Time) {
+		return nil, errors.New("incomplete Open-Meteo hourly forecast")
+	}
+	var forecast []ForecastPeriod
+	for i, ts := range hourly.Time {
+		temp := hourly.Temperature[i]
+		if req.Units == UnitsStandard {
+			temp = fromCelsius(temp, UnitsStandard)
+		}
+		forecast = append(forecast, ForecastPeriod{
+			Time:                     time.Unix(ts, 0),
+			Temp:                     temp,
+			PrecipitationProbability: hourly.PrecipitationProbability[i],
+		})
+	}
+	return forecast, nil
+}
+
+func (p *OpenMeteoProvider) Geocode(ctx context.Context, req GeocodeRequest) (*Location, error) {
+	// the search matches both place names and postal codes
+	name := req.City
+	if len(req.Zip) > 0 {
+		name = req.Zip
+	}
+	query := url.Values{}
+	query.Set("name", name)
+	query.Set("count", "1")
+	if len(req.CountryCode) > 0 {
+		query.Set("countryCode", req.CountryCode)
+	}
+	var jResponse OpenMeteoGeocodingResponse
+	err := p.get(ctx, p.GeocodingUrl, query, &jResponse)
+	if err != nil {
+		return nil, err
+	}
+	if len(jResponse.Results) == 0 {
+		return nil, &LocationNotFoundError{Query: geocodeQuery(req)}
+	}
+	result := jResponse.Results[0]
+	return &Location{Lat: result.Latitude, Lon: result.Longitude, Name: result.Name}, nil
+}
+
+// query returns the location and unit parameters common to all Open-Meteo requests
+func (p *OpenMeteoProvider) query(req ObservationRequest) url.Values {
+	query := url.Values{}
+	query.Set("latitude", req.Lat)
+	query.Set("longitude", req.Lon)
+	if req.Units == UnitsImperial {
+		query.Set("temperature_unit", "fahrenheit")
+		query.Set("wind_speed_unit", "mph")
+	} else {
+		// Open-Meteo has no Kelvin option, so standard units are converted from Celsius
+		query.Set("temperature_unit", "celsius")
+		query.Set("wind_speed_unit", "ms")
+	}
+	query.Set("timeformat", "unixtime")
+	return query
+}
+
+// get queries an Open-Meteo API and parses the JSON response into out
+func (p *OpenMeteoProvider) get(ctx context.Context, baseUrl string, query url.Values, out interface{}) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, baseUrl+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.HttpClient.Do(httpReq)
+	if err != nil {
+		return transientError(p.Name(), err)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return rateLimitedResponse(p.Name(), resp)
+	}
+	if resp.StatusCode != 200 {
+		return statusCodeError(p.Name(), resp.StatusCode)
+	}
+
+	// read and parse the Open-Meteo response data
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return transientError(p.Name(), err)
+	}
+	err = json.Unmarshal(data, out)
+	if err != nil {
+		return decodeError(p.Name(), fmt.Errorf("unable to parse JSON response into %T: %w", out, err))
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const openMeteoSample = `{
+  "latitude": 38.45,
+  "longitude": -77.99,
+  "timezone": "GMT",
+  "current_units": {
+    "time": "unixtime",
+    "interval": "seconds",
+    "temperature_2m": "°F",
+    "relative_humidity_2m": "%",
+    "pressure_msl": "hPa",
+    "wind_speed_10m": "mp/h",
+    "wind_gusts_10m": "mp/h"
+  },
+  "current": {
+    "time": 1650000000,
+    "interval": 900,
+    "temperature_2m": 61.3,
+    "relative_humidity_2m": 44,
+    "pressure_msl": 1015.4,
+    "wind_speed_10m": 6.2,
+    "wind_gusts_10m": 14.8
+  }
+}`
+
+const openMeteoForecastSample = `{
+  "latitude": 38.45,
+  "longitude": -77.99,
+  "hourly_units": {"time": "unixtime", "temperature_2m": "°C", "precipitation_probability": "%"},
+  "hourly": {
+    "time": [1650002400, 1650006000],
+    "temperature_2m": [16.5, 15.25],
+    "precipitation_probability": [5, 40]
+  }
+}`
+
+const openMeteoGeocodingSample = `{
+  "results": [
+    {"id": 4751935, "name": "Culpeper", "latitude": 38.47318, "longitude": -77.99666, "country_code": "US", "admin1": "Virginia"}
+  ],
+  "generationtime_ms": 0.5
+}`
+
+var _ = Describe("OpenMeteoProvider", func() {
+	var (
+		server *httptest.Server
+		query  map[string]string
+	)
+
+	BeforeEach(func() {
+		query = map[string]string{}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for k := range r.URL.Query() {
+				query[k] = r.URL.Query().Get(k)
+			}
+			if r.URL.Path == "/search" {
+				if r.URL.Query().Get("name") == "Nowhere" {
+					_, _ = w.Write([]byte(`{"generationtime_ms": 0.1}`))
+					return
+				}
+				_, _ = w.Write([]byte(openMeteoGeocodingSample))
+				return
+			}
+			if r.URL.Query().Get("hourly") != "" {
+				_, _ = w.Write([]byte(openMeteoForecastSample))
+				return
+			}
+			_, _ = w.Write([]byte(openMeteoSample))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("maps the current block into an observation", func() {
+		p := NewOpenMeteoProvider()
+		p.BaseUrl = server.URL
+		obs, err := p.CurrentConditions(context.Background(), ObservationRequest{Lat: "38.44", Lon: "-77.98", Units: UnitsImperial})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query).To(HaveKeyWithValue("latitude", "38.44"))
+		Expect(query).To(HaveKeyWithValue("longitude", "-77.98"))
+		Expect(query).To(HaveKeyWithValue("current", OpenMeteoCurrentVariables))
+		Expect(query).To(HaveKeyWithValue("temperature_unit", "fahrenheit"))
+		Expect(obs.Temp).To(Equal(61.3))
+		Expect(*obs.Pressure).To(Equal(int64(1015)))
+		Expect(*obs.Humidity).To(Equal(int64(44)))
+		Expect(*obs.WindSpeed).To(Equal(6.2))
+		Expect(*obs.WindGust).To(Equal(14.8))
+		Expect(obs.Time.Unix()).To(Equal(int64(1650000000)))
+	})
+
+	It("maps the hourly block into forecast periods", func() {
+		p := NewOpenMeteoProvider()
+		p.BaseUrl = server.URL
+		forecast, err := p.Forecast(context.Background(), ObservationRequest{Lat: "38.44", Lon: "-77.98", Units: UnitsStandard}, 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query).To(HaveKeyWithValue("hourly", OpenMeteoHourlyVariables))
+		Expect(query).To(HaveKeyWithValue("forecast_hours", "2"))
+		Expect(query).To(HaveKeyWithValue("temperature_unit", "celsius"))
+		Expect(forecast).To(HaveLen(2))
+		Expect(forecast[1].Time.Unix()).To(Equal(int64(1650006000)))
+		Expect(forecast[1].Temp).To(BeNumerically("~", 288.4, 0.001))
+		Expect(forecast[1].PrecipitationProbability).To(Equal(40.0))
+	})
+
+	It("geocodes a city or postal code through the search API", func() {
+		p := NewOpenMeteoProvider()
+		p.GeocodingUrl = server.URL + "/search"
+		location, err := p.Geocode(context.Background(), GeocodeRequest{City: "Culpeper", CountryCode: "US"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query).To(HaveKeyWithValue("name", "Culpeper"))
+		Expect(query).To(HaveKeyWithValue("countryCode", "US"))
+		Expect(query).To(HaveKeyWithValue("count", "1"))
+		Expect(location).To(Equal(&Location{Lat: 38.47318, Lon: -77.99666, Name: "Culpeper"}))
+
+		_, err = p.Geocode(context.Background(), GeocodeRequest{Zip: "22701"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query).To(HaveKeyWithValue("name", "22701"))
+
+		_, err = p.Geocode(context.Background(), GeocodeRequest{City: "Nowhere"})
+		Expect(err).To(Equal(&LocationNotFoundError{Query: "Nowhere"}))
+	})
+})
@@ -0,0 +1,390 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/json"
+
+	weatherv1 "alsup/api/v1"
+)
+
+const WeatherUrl = "https://api.openweathermap.org/data/2.5/weather"
+const ForecastUrl = "https://api.openweathermap.org/data/2.5/forecast"
+const OneCallUrl = "https://api.openweathermap.org/data/3.0/onecall"
+const GeocodingUrl = "https://api.openweathermap.org/geo/1.0"
+const AirPollutionUrl = "https://api.openweathermap.org/data/2.5/air_pollution"
+
+type OpenWeatherMapResponse struct {
+	Coord struct {
+		Lon float64 `json:"lon"`
+		Lat float64 `json:"lat"`
+	} `json:"coord"`
+	Weather []struct {
+		Id          int    `json:"id"`
+		Main        string `json:"main"`
+		Description string `json:"description"`
+		Icon        string `json:"icon"`
+	} `json:"weather"`
+	Base string `json:"base"`
+	Main struct {
+		Temp      float64 `json:"temp"`
+		FeelsLike float64 `json:"feels_like"`
+		TempMin   float64 `json:"temp_min"`
+		TempMax   float64 `json:"temp_max"`
+		Pressure  int64   `json:"pressure"`
+		Humidity  int64   `json:"humidity"`
+	} `json:"main"`
+	Visibility *uint32 `json:"visibility"`
+	Wind       struct {
+		Speed float64 `json:"speed"`
+		Deg   uint16  `json:"deg"`
+		Gust  float64 `json:"gust"`
+	} `json:"wind"`
+	Clouds struct {
+		All uint16 `json:"all"`
+	} `json:"clouds"`
+	Rain     *OpenWeatherMapPrecipitation `json:"rain"`
+	Snow     *OpenWeatherMapPrecipitation `json:"snow"`
+	DateTime int64                        `json:"dt"`
+	Sys      struct {
+		Type    uint16  `json:"type"`
+		Id      uint32  `json:"id"`
+		Message float64 `json:"message"`
+		Country string  `json:"country"`
+		Sunrise uint64  `json:"sunrise"`
+		Sunset  uint64  `json:"sunset"`
+	} `json:"sys"`
+	Timezone int    `json:"timezone"`
+	Id       uint32 `json:"id"`
+	Name     string `json:"name"`
+	Cod      uint16 `json:"cod"`
+}
+
+// OpenWeatherMapPrecipitation is the rain or snow volume in mm, only present when there was some
+type OpenWeatherMapPrecipitation struct {
+	OneHour    *float64 `json:"1h"`
+	ThreeHours *float64 `json:"3h"`
+}
+
+// OpenWeatherMapForecastResponse is the 5 day / 3 hour forecast
+type OpenWeatherMapForecastResponse struct {
+	Cnt  int `json:"cnt"`
+	List []struct {
+		DateTime int64 `json:"dt"`
+		Main     struct {
+			Temp float64 `json:"temp"`
+		} `json:"main"`
+		Weather []struct {
+			Main        string `json:"main"`
+			Description string `json:"description"`
+		} `json:"weather"`
+		Pop float64 `json:"pop"`
+	} `json:"list"`
+}
+
+// OpenWeatherMapOneCallResponse is the One Call API response, requested with only its alerts
+type OpenWeatherMapOneCallResponse struct {
+	Alerts []struct {
+		SenderName  string   `json:"sender_name"`
+		Event       string   `json:"event"`
+		Start       int64    `json:"start"`
+		End         int64    `json:"end"`
+		Description string   `json:"description"`
+		Tags        []string `json:"tags"`
+	} `json:"alerts"`
+}
+
+// OpenWeatherMapAirPollutionResponse is the current air pollution, as a single item list
+type OpenWeatherMapAirPollutionResponse struct {
+	List []struct {
+		DateTime int64 `json:"dt"`
+		Main     struct {
+			Aqi int `json:"aqi"`
+		} `json:"main"`
+		Components struct {
+			Co   float64 `json:"co"`
+			No   float64 `json:"no"`
+			No2  float64 `json:"no2"`
+			O3   float64 `json:"o3"`
+			So2  float64 `json:"so2"`
+			Pm25 float64 `json:"pm2_5"`
+			Pm10 float64 `json:"pm10"`
+			Nh3  float64 `json:"nh3"`
+		} `json:"components"`
+	} `json:"list"`
+}
+
+// OpenWeatherMapGeocodingResponse is a place returned by the OpenWeatherMap geocoding API;
+// the direct (city) endpoint returns a list of them, the zip endpoint a single one
+type OpenWeatherMapGeocodingResponse struct {
+	Name    string  `json:"name"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	Country string  `json:"country"`
+}
+
+// OpenWeatherMapProvider queries the OpenWeatherMap current weather, forecast, One Call (alerts), air pollution
+// and geocoding APIs
+type OpenWeatherMapProvider struct {
+	BaseUrl         string
+	ForecastUrl     string
+	OneCallUrl      string
+	AirPollutionUrl string
+	GeocodingUrl    string
+	HttpClient      *http.Client
+}
+
+func NewOpenWeatherMapProvider() *OpenWeatherMapProvider {
+	return &OpenWeatherMapProvider{
+		BaseUrl:         WeatherUrl,
+		ForecastUrl:     ForecastUrl,
+		OneCallUrl:      OneCallUrl,
+		AirPollutionUrl: AirPollutionUrl,
+		GeocodingUrl:    GeocodingUrl,
+		HttpClient:      newProviderHttpClient(weatherv1.ProviderOpenWeatherMap),
+	}
+}
+
+func (p *OpenWeatherMapProvider) Name() string {
+	return weatherv1.ProviderOpenWeatherMap
+}
+
+func (p *OpenWeatherMapProvider) RequiresToken() bool {
+	return true
+}
+
+func (p *OpenWeatherMapProvider) CurrentConditions(ctx context.Context, req ObservationRequest) (*Observation, error) {
+	var jResponse OpenWeatherMapResponse
+	err := p.get(ctx, p.BaseUrl, p.query(req), &jResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	obs := &Observation{
+		Time:         time.Unix(jResponse.DateTime, 0),
+		CountryCode:  jResponse.Sys.Country,
+		LocationName: jResponse.Name,
+		Temp:         jResponse.Main.Temp,
+		Pressure:     int64Ptr(jResponse.Main.Pressure),
+		Humidity:     int64Ptr(jResponse.Main.Humidity),
+		WindSpeed:    &jResponse.Wind.Speed,
+		WindGust:     &jResponse.Wind.Gust,
+		FeelsLike:    &jResponse.Main.FeelsLike,
+		TempMin:      &jResponse.Main.TempMin,
+		TempMax:      &jResponse.Main.TempMax,
+		WindDeg:      float64Ptr(float64(jResponse.Wind.Deg)),
+		CloudCover:   float64Ptr(float64(jResponse.Clouds.All)),
+	}
+	// there is no sunrise or sunset during polar day and night, when they are omitted or 0
+	if jResponse.Sys.Sunrise > 0 {
+		obs.Sunrise = time.Unix(int64(jResponse.Sys.Sunrise), 0)
+	}
+	if jResponse.Sys.Sunset > 0 {
+		obs.Sunset = time.Unix(int64(jResponse.Sys.Sunset), 0)
+	}
+	if jResponse.Visibility != nil {
+		obs.Visibility = float64Ptr(float64(*jResponse.Visibility))
+	}
+	// the first entry of weather[] is the primary condition
+	if len(jResponse.Weather) > 0 {
+		condition := jResponse.Weather[0]
+		obs.Condition = &Condition{
+			Id:          int32(condition.Id),
+			Main:        condition.Main,
+			Description: condition.Description,
+			Icon:        condition.Icon,
+		}
+	}
+	obs.Rain = jResponse.Rain.volume()
+	obs.Snow = jResponse.Snow.volume()
+	return obs, nil
+}
+
+func (p *OpenWeatherMapProvider) Forecast(ctx context.Context, req ObservationRequest, periods int) ([]ForecastPeriod, error) {
+	query := p.query(req)
+	query.Set("cnt", strconv.Itoa(periods))
+	var jResponse OpenWeatherMapForecastResponse
+	err := p.get(ctx, p.ForecastUrl, query, &jResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	var forecast []ForecastPeriod
+	for _, item := range jResponse.List {
+		period := ForecastPeriod{
+			Time: time.Unix(item.DateTime, 0),
+			Temp: item.Main.Temp,
+			// OpenWeatherMap reports the probability of precipitation as a fraction
+			PrecipitationProbability: item.Pop * 100,
+		}
+		if len(item.Weather) > 0 {
+			period.Summary = item.Weather[0].Description
+		}
+		forecast = append(forecast, period)
+	}
+	return forecast, nil
+}
+
+func (p *OpenWeatherMapProvider) Alerts(ctx context.Context, req ObservationRequest) ([]Alert, error) {
+	query := p.query(req)
+	query.Set("exclude", "current,minutely,hourly,daily")
+	var jResponse OpenWeatherMapOneCallResponse
+	err := p.get(ctx, p.OneCallUrl, query, &jResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	var alerts []Alert
+	for _, item := range jResponse.Alerts {
+		// One Call alerts have no id or severity
+		alerts = append(alerts, Alert{
+			Id:       fmt.Sprintf("%s/%s/%d", item.SenderName, item.Event, item.Start),
+			Event:    item.Event,
+			Headline: firstLine(item.Description),
+			Severity: "Unknown",
+			Sender:   item.SenderName,
+			Start:    time.Unix(item.Start, 0),
+			End:      time.Unix(item.End, 0),
+		})
+	}
+	return alerts, nil
+}
+
+func (p *OpenWeatherMapProvider) AirQuality(ctx context.Context, req ObservationRequest) (*AirQuality, error) {
+	query := p.query(req)
+	// concentrations are always in µg/m³
+	query.Del("units")
+	var jResponse OpenWeatherMapAirPollutionResponse
+	err := p.get(ctx, p.AirPollutionUrl, query, &jResponse)
+	if err != nil {
+		return nil, err
+	}
+	if len(jResponse.List) == 0 {
+		return nil, errors.New("no air pollution data in OpenWeatherMap response")
+	}
+
+	item := jResponse.List[0]
+	return &AirQuality{
+		Time:  time.Unix(item.DateTime, 0),
+		Index: item.Main.Aqi,
+		CO:    item.Components.Co,
+		NO2:   item.Components.No2,
+		O3:    item.Components.O3,
+		SO2:   item.Components.So2,
+		PM25:  item.Components.Pm25,
+		PM10:  item.Components.Pm10,
+	}, nil
+}
+
+func (p *OpenWeatherMapProvider) Geocode(ctx context.Context, req GeocodeRequest) (*Location, error) {
+	query := url.Values{}
+	query.Set("appid", req.Token)
+
+	var place OpenWeatherMapGeocodingResponse
+	if len(req.Zip) > 0 {
+		query.Set("zip", geocodeQuery(req))
+		err := p.get(ctx, p.GeocodingUrl+"/zip", query, &place)
+		var providerErr *ProviderError
+		if errors.As(err, &providerErr) && providerErr.StatusCode == http.StatusNotFound {
+			return nil, &LocationNotFoundError{Query: geocodeQuery(req)}
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		query.Set("q", geocodeQuery(req))
+		query.Set("limit", "1")
+		var places []OpenWeatherMapGeocodingResponse
+		err := p.get(ctx, p.GeocodingUrl+"/direct", query, &places)
+		if err != nil {
+			return nil, err
+		}
+		if len(places) == 0 {
+			return nil, &LocationNotFoundError{Query: geocodeQuery(req)}
+		}
+		place = places[0]
+	}
+	return &Location{Lat: place.Lat, Lon: place.Lon, Name: place.Name}, nil
+}
+
+// query returns the query parameters common to all OpenWeatherMap requests
+func (p *OpenWeatherMapProvider) query(req ObservationRequest) url.Values {
+	query := url.Values{}
+	query.Set("lat", req.Lat)
+	query.Set("lon", req.Lon)
+	query.Set("units", req.Units)
+	query.Set("appid", req.Token)
+	return query
+}
+
+// get fetches an OpenWeatherMap resource and parses the JSON response into out
+func (p *OpenWeatherMapProvider) get(ctx context.Context, baseUrl string, query url.Values, out interface{}) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, baseUrl+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.HttpClient.Do(httpReq)
+	if err != nil {
+		return transientError(p.Name(), err)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return rateLimitedResponse(p.Name(), resp)
+	}
+	if resp.StatusCode != 200 {
+		return statusCodeError(p.Name(), resp.StatusCode)
+	}
+
+	// read and parse the OpenWeatherMap response data
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return transientError(p.Name(), err)
+	}
+	err = json.Unmarshal(data, out)
+	if err != nil {
+		return decodeError(p.Name(), fmt.Errorf("unable to parse JSON response into %T: %w", out, err))
+	}
+	return nil
+}
+
+func (v *OpenWeatherMapPrecipitation) volume() *PrecipitationVolume {
+	if v == nil {
+		return nil
+	}
+	return &PrecipitationVolume{LastHour: v.OneHour, Last3Hours: v.ThreeHours}
+}
+
+// firstLine returns the first non-empty line of an alert description
+func firstLine(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			return line
+		}
+	}
+	return ""
+}
@@ -0,0 +1,286 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const openWeatherMapSample = `{
+  "coord": {"lon": -77.9883, "lat": 38.4465},
+  "weather": [{"id": 800, "main": "Clear", "description": "clear sky", "icon": "01d"}],
+  "main": {"temp": 61.54, "feels_like": 59.5, "temp_min": 58.1, "temp_max": 64.2, "pressure": 1015, "humidity": 41},
+  "visibility": 10000,
+  "wind": {"speed": 5.75, "deg": 250, "gust": 12.1},
+  "clouds": {"all": 20},
+  "rain": {"1h": 0.25},
+  "dt": 1650000000,
+  "sys": {"type": 2, "id": 2000, "country": "US", "sunrise": 1649975000, "sunset": 1650022000},
+  "timezone": -14400,
+  "id": 4751935,
+  "name": "Culpeper",
+  "cod": 200
+}`
+
+const openWeatherMapForecastSample = `{
+  "cod": "200",
+  "cnt": 2,
+  "list": [
+    {"dt": 1650006000, "main": {"temp": 63.1}, "weather": [{"main": "Clouds", "description": "few clouds"}], "pop": 0},
+    {"dt": 1650016800, "main": {"temp": 58.4}, "weather": [{"main": "Rain", "description": "light rain"}], "pop": 0.62}
+  ]
+}`
+
+const openWeatherMapOneCallSample = `{
+  "lat": 38.4465,
+  "lon": -77.9883,
+  "alerts": [
+    {
+      "sender_name": "NWS Sterling VA",
+      "event": "Wind Advisory",
+      "start": 1650024000,
+      "end": 1650060000,
+      "description": "...WIND ADVISORY IN EFFECT FROM NOON TO 10 PM EDT...\n* WHAT...West winds 20 to 30 mph.",
+      "tags": ["Wind"]
+    }
+  ]
+}`
+
+const openWeatherMapAirPollutionSample = `{
+  "coord": {"lon": -77.9883, "lat": 38.4465},
+  "list": [
+    {
+      "dt": 1650000000,
+      "main": {"aqi": 2},
+      "components": {"co": 230.31, "no": 0.01, "no2": 3.3, "o3": 68.66, "so2": 1.19, "pm2_5": 2.03, "pm10": 3.14, "nh3": 0.4}
+    }
+  ]
+}`
+
+const openWeatherMapDirectGeocodingSample = `[
+  {"name": "Culpeper", "lat": 38.4731, "lon": -77.9966, "country": "US", "state": "Virginia"}
+]`
+
+const openWeatherMapZipGeocodingSample = `{"zip": "22701", "name": "Culpeper", "lat": 38.4387, "lon": -77.9969, "country": "US"}`
+
+var _ = Describe("OpenWeatherMapProvider", func() {
+	var (
+		server *httptest.Server
+		query  map[string]string
+		status int
+	)
+
+	BeforeEach(func() {
+		status = http.StatusOK
+		query = map[string]string{}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for k := range r.URL.Query() {
+				query[k] = r.URL.Query().Get(k)
+			}
+			if status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "30")
+			}
+			w.WriteHeader(status)
+			if r.URL.Path == "/onecall" {
+				_, _ = w.Write([]byte(openWeatherMapOneCallSample))
+				return
+			}
+			if r.URL.Path == "/air_pollution" {
+				_, _ = w.Write([]byte(openWeatherMapAirPollutionSample))
+				return
+			}
+			if r.URL.Path == "/geo/direct" {
+				if query["q"] == "Nowhere,US" {
+					_, _ = w.Write([]byte(`[]`))
+					return
+				}
+				_, _ = w.Write([]byte(openWeatherMapDirectGeocodingSample))
+				return
+			}
+			if r.URL.Path == "/geo/zip" {
+				_, _ = w.Write([]byte(openWeatherMapZipGeocodingSample))
+				return
+			}
+			if r.URL.Path == "/forecast" {
+				_, _ = w.Write([]byte(openWeatherMapForecastSample))
+				return
+			}
+			_, _ = w.Write([]byte(openWeatherMapSample))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newProvider := func() *OpenWeatherMapProvider {
+		p := NewOpenWeatherMapProvider()
+		p.BaseUrl = server.URL
+		p.ForecastUrl = server.URL + "/forecast"
+		p.OneCallUrl = server.URL + "/onecall"
+		p.AirPollutionUrl = server.URL + "/air_pollution"
+		p.GeocodingUrl = server.URL + "/geo"
+		return p
+	}
+
+	It("maps the response into an observation", func() {
+		obs, err := newProvider().CurrentConditions(context.Background(), ObservationRequest{Lat: "38.44", Lon: "-77.98", Units: UnitsMetric, Token: "abc"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query).To(HaveKeyWithValue("lat", "38.44"))
+		Expect(query).To(HaveKeyWithValue("lon", "-77.98"))
+		Expect(query).To(HaveKeyWithValue("appid", "abc"))
+		Expect(query).To(HaveKeyWithValue("units", "metric"))
+		Expect(obs.Temp).To(Equal(61.54))
+		Expect(*obs.Pressure).To(Equal(int64(1015)))
+		Expect(*obs.Humidity).To(Equal(int64(41)))
+		Expect(*obs.WindSpeed).To(Equal(5.75))
+		Expect(*obs.WindGust).To(Equal(12.1))
+		Expect(obs.CountryCode).To(Equal("US"))
+		Expect(obs.LocationName).To(Equal("Culpeper"))
+		Expect(obs.Time.Unix()).To(Equal(int64(1650000000)))
+	})
+
+	It("maps the optional parts of the response", func() {
+		obs, err := newProvider().CurrentConditions(context.Background(), ObservationRequest{Lat: "38.44", Lon: "-77.98", Units: UnitsMetric, Token: "abc"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*obs.FeelsLike).To(Equal(59.5))
+		Expect(*obs.TempMin).To(Equal(58.1))
+		Expect(*obs.TempMax).To(Equal(64.2))
+		Expect(*obs.Visibility).To(Equal(10000.0))
+		Expect(*obs.WindDeg).To(Equal(250.0))
+		Expect(*obs.CloudCover).To(Equal(20.0))
+		Expect(obs.Condition).To(Equal(&Condition{Id: 800, Main: "Clear", Description: "clear sky", Icon: "01d"}))
+		Expect(obs.Sunrise.Unix()).To(Equal(int64(1649975000)))
+		Expect(obs.Sunset.Unix()).To(Equal(int64(1650022000)))
+		Expect(*obs.Rain.LastHour).To(Equal(0.25))
+		Expect(obs.Rain.Last3Hours).To(BeNil())
+		Expect(obs.Snow).To(BeNil())
+	})
+
+	It("leaves out sunrise and sunset during polar day and night", func() {
+		server.Close()
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			polar := strings.Replace(openWeatherMapSample, `"sunrise": 1649975000, "sunset": 1650022000`, `"sunrise": 0`, 1)
+			_, _ = w.Write([]byte(polar))
+		}))
+		obs, err := newProvider().CurrentConditions(context.Background(), ObservationRequest{Lat: "78.22", Lon: "15.65", Units: UnitsMetric, Token: "abc"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obs.Sunrise.IsZero()).To(BeTrue())
+		Expect(obs.Sunset.IsZero()).To(BeTrue())
+	})
+
+	It("returns an error for non-200 responses", func() {
+		status = http.StatusUnauthorized
+		_, err := newProvider().CurrentConditions(context.Background(), ObservationRequest{Lat: "38.44", Lon: "-77.98", Units: UnitsMetric, Token: "abc"})
+		Expect(err).To(MatchError(ContainSubstring("401")))
+		Expect(errorKind(err)).To(Equal(ErrorAuth))
+	})
+
+	It("classifies network errors as transient and unparseable responses as decode errors", func() {
+		p := newProvider()
+		server.Close()
+		_, err := p.CurrentConditions(context.Background(), ObservationRequest{Lat: "38.44", Lon: "-77.98", Units: UnitsMetric, Token: "abc"})
+		Expect(errorKind(err)).To(Equal(ErrorTransient))
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"main": "not an object"}`))
+		}))
+		_, err = newProvider().CurrentConditions(context.Background(), ObservationRequest{Lat: "38.44", Lon: "-77.98", Units: UnitsMetric, Token: "abc"})
+		Expect(errorKind(err)).To(Equal(ErrorDecode))
+	})
+
+	It("returns a RateLimitedError honoring Retry-After for 429 responses", func() {
+		status = http.StatusTooManyRequests
+		_, err := newProvider().CurrentConditions(context.Background(), ObservationRequest{Lat: "38.44", Lon: "-77.98", Units: UnitsMetric, Token: "abc"})
+		Expect(err).To(Equal(&RateLimitedError{Provider: "openweathermap", RetryAfter: 30 * time.Second}))
+	})
+
+	It("maps the 3-hourly forecast into forecast periods", func() {
+		forecast, err := newProvider().Forecast(context.Background(), ObservationRequest{Lat: "38.44", Lon: "-77.98", Units: UnitsImperial, Token: "abc"}, 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query).To(HaveKeyWithValue("cnt", "2"))
+		Expect(query).To(HaveKeyWithValue("appid", "abc"))
+		Expect(forecast).To(Equal([]ForecastPeriod{
+			{Time: time.Unix(1650006000, 0), Temp: 63.1, PrecipitationProbability: 0, Summary: "few clouds"},
+			{Time: time.Unix(1650016800, 0), Temp: 58.4, PrecipitationProbability: 62, Summary: "light rain"},
+		}))
+	})
+
+	It("maps One Call alerts", func() {
+		alerts, err := newProvider().Alerts(context.Background(), ObservationRequest{Lat: "38.44", Lon: "-77.98", Units: UnitsImperial, Token: "abc"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query).To(HaveKeyWithValue("exclude", "current,minutely,hourly,daily"))
+		Expect(alerts).To(Equal([]Alert{{
+			Id:       "NWS Sterling VA/Wind Advisory/1650024000",
+			Event:    "Wind Advisory",
+			Headline: "...WIND ADVISORY IN EFFECT FROM NOON TO 10 PM EDT...",
+			Severity: "Unknown",
+			Sender:   "NWS Sterling VA",
+			Start:    time.Unix(1650024000, 0),
+			End:      time.Unix(1650060000, 0),
+		}}))
+	})
+
+	It("maps the air pollution", func() {
+		aq, err := newProvider().AirQuality(context.Background(), ObservationRequest{Lat: "38.44", Lon: "-77.98", Units: UnitsImperial, Token: "abc"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query).To(HaveKeyWithValue("appid", "abc"))
+		Expect(query).NotTo(HaveKey("units"))
+		Expect(aq).To(Equal(&AirQuality{
+			Time:  time.Unix(1650000000, 0),
+			Index: 2,
+			CO:    230.31,
+			NO2:   3.3,
+			O3:    68.66,
+			SO2:   1.19,
+			PM25:  2.03,
+			PM10:  3.14,
+		}))
+	})
+
+	It("geocodes a city through the direct endpoint", func() {
+		location, err := newProvider().Geocode(context.Background(), GeocodeRequest{City: "Culpeper", CountryCode: "US", Token: "abc"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query).To(HaveKeyWithValue("q", "Culpeper,US"))
+		Expect(query).To(HaveKeyWithValue("limit", "1"))
+		Expect(query).To(HaveKeyWithValue("appid", "abc"))
+		Expect(location).To(Equal(&Location{Lat: 38.4731, Lon: -77.9966, Name: "Culpeper"}))
+	})
+
+	It("geocodes a postal code through the zip endpoint", func() {
+		location, err := newProvider().Geocode(context.Background(), GeocodeRequest{Zip: "22701", CountryCode: "US", Token: "abc"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query).To(HaveKeyWithValue("zip", "22701,US"))
+		Expect(location).To(Equal(&Location{Lat: 38.4387, Lon: -77.9969, Name: "Culpeper"}))
+	})
+
+	It("returns a LocationNotFoundError for unknown places", func() {
+		_, err := newProvider().Geocode(context.Background(), GeocodeRequest{City: "Nowhere", CountryCode: "US", Token: "abc"})
+		Expect(err).To(Equal(&LocationNotFoundError{Query: "Nowhere,US"}))
+
+		status = http.StatusNotFound
+		_, err = newProvider().Geocode(context.Background(), GeocodeRequest{Zip: "00000", CountryCode: "US", Token: "abc"})
+		Expect(err).To(Equal(&LocationNotFoundError{Query: "00000,US"}))
+	})
+})
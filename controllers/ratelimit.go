@@ -0,0 +1,151 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RateLimitAnnotation on a provider token secret overrides the per-secret rate limit, in calls per minute
+const RateLimitAnnotation = "weather.alsup/rate-limit"
+
+// Default rate limits in calls per minute, matching the OpenWeatherMap free tier
+const (
+	DefaultRateLimit       = 60
+	DefaultSecretRateLimit = 60
+)
+
+// RateLimiter holds token buckets for provider calls: one per provider shared by every Weather, and
+// one per API token secret. Limits are in calls per minute, with a burst of a minute's calls; a limit
+// of 0 disables that bucket. A nil RateLimiter allows every call.
+type RateLimiter struct {
+	// Limit is the calls per minute allowed to each provider
+	Limit int
+	// SecretLimit is the calls per minute allowed with each secret, unless overridden by RateLimitAnnotation
+	SecretLimit int
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	now      func() time.Time
+}
+
+func NewRateLimiter(limit int, secretLimit int) *RateLimiter {
+	return &RateLimiter{
+		Limit:       limit,
+		SecretLimit: secretLimit,
+		limiters:    map[string]*rate.Limiter{},
+		now:         time.Now,
+	}
+}
+
+// Reserve takes a token from the provider bucket and, when secret is set, from the secret bucket.
+// When either is empty no token is taken, and the time until the call would be allowed is returned.
+func (l *RateLimiter) Reserve(provider string, secret *corev1.Secret) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var reservations []*rate.Reservation
+	if limiter := l.limiterLocked("provider/"+provider, l.Limit); limiter != nil {
+		reservations = append(reservations, limiter.ReserveN(now, 1))
+	}
+	if secret != nil {
+		key := "secret/" + client.ObjectKeyFromObject(secret).String()
+		if limiter := l.limiterLocked(key, secretRateLimit(secret, l.SecretLimit)); limiter != nil {
+			reservations = append(reservations, limiter.ReserveN(now, 1))
+		}
+	}
+
+	var delay time.Duration
+	for _, r := range reservations {
+		if d := r.DelayFrom(now); d > delay {
+			delay = d
+		}
+	}
+	if delay > 0 {
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+	}
+	return delay
+}
+
+// limiterLocked returns the bucket for key, updated to perMinute, or nil when unlimited; l.mu must be held
+func (l *RateLimiter) limiterLocked(key string, perMinute int) *rate.Limiter {
+	if perMinute <= 0 {
+		delete(l.limiters, key)
+		return nil
+	}
+	limit := rate.Limit(float64(perMinute) / time.Minute.Seconds())
+	limiter, ok := l.limiters[key]
+	if !ok {
+		limiter = rate.NewLimiter(limit, perMinute)
+		l.limiters[key] = limiter
+	} else if limiter.Limit() != limit || limiter.Burst() != perMinute {
+		limiter.SetLimitAt(l.now(), limit)
+		limiter.SetBurstAt(l.now(), perMinute)
+	}
+	return limiter
+}
+
+// secretRateLimit returns the calls per minute allowed with a secret, from RateLimitAnnotation or the default
+func secretRateLimit(secret *corev1.Secret, defaultLimit int) int {
+	if value, ok := secret.Annotations[RateLimitAnnotation]; ok {
+		if limit, err := strconv.Atoi(value); err == nil && limit >= 0 {
+			return limit
+		}
+	}
+	return defaultLimit
+}
+
+// rateLimitedProvider wraps a provider for the calls of one Weather, so the calls that reach the
+// provider (rather than being served from the ObservationCache) are rate limited and counted
+type rateLimitedProvider struct {
+	WeatherProvider
+	limiter *RateLimiter
+	// secret holds the API token, nil for providers that need no token
+	secret *corev1.Secret
+}
+
+func (p *rateLimitedProvider) CurrentConditions(ctx context.Context, req ObservationRequest) (*Observation, error) {
+	if err := p.reserve(); err != nil {
+		return nil, err
+	}
+	return p.WeatherProvider.CurrentConditions(ctx, req)
+}
+
+// reserve takes a rate limiter token for a provider call, returning a RateLimitedError when there is none
+func (p *rateLimitedProvider) reserve() error {
+	if delay := p.limiter.Reserve(p.Name(), p.secret); delay > 0 {
+		providerThrottled.WithLabelValues(p.Name()).Inc()
+		return &RateLimitedError{Provider: p.Name(), RetryAfter: delay}
+	}
+	if p.secret != nil {
+		providerSecretCalls.WithLabelValues(p.Name(), p.secret.Namespace, p.secret.Name).Inc()
+	}
+	return nil
+}
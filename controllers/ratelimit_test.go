@@ -0,0 +1,94 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("RateLimiter", func() {
+	var (
+		limiter *RateLimiter
+		now     time.Time
+		secret  *corev1.Secret
+	)
+
+	BeforeEach(func() {
+		now = time.Unix(1650000000, 0)
+		limiter = NewRateLimiter(3, 2)
+		limiter.now = func() time.Time { return now }
+		secret = newTestSecret()
+	})
+
+	It("allows a burst of a minute's calls per provider, then refills", func() {
+		for i := 0; i < 3; i++ {
+			Expect(limiter.Reserve("fake", nil)).To(BeZero())
+		}
+		Expect(limiter.Reserve("fake", nil)).To(Equal(20 * time.Second))
+		Expect(limiter.Reserve("other", nil)).To(BeZero())
+
+		now = now.Add(20 * time.Second)
+		Expect(limiter.Reserve("fake", nil)).To(BeZero())
+	})
+
+	It("limits the calls made with each secret", func() {
+		Expect(limiter.Reserve("fake", secret)).To(BeZero())
+		Expect(limiter.Reserve("fake", secret)).To(BeZero())
+		Expect(limiter.Reserve("fake", secret)).To(Equal(30 * time.Second))
+		// the deferred call did not use up the provider bucket
+		Expect(limiter.Reserve("fake", nil)).To(BeZero())
+		Expect(limiter.Reserve("fake", nil)).To(Equal(20 * time.Second))
+	})
+
+	It("honors the rate limit annotation of a secret", func() {
+		secret.Annotations = map[string]string{RateLimitAnnotation: "1"}
+		Expect(limiter.Reserve("fake", secret)).To(BeZero())
+		Expect(limiter.Reserve("fake", secret)).To(Equal(time.Minute))
+	})
+
+	It("does not limit when the limits are 0 or the limiter is nil", func() {
+		limiter = NewRateLimiter(0, 0)
+		var disabled *RateLimiter
+		for i := 0; i < 100; i++ {
+			Expect(limiter.Reserve("fake", secret)).To(BeZero())
+			Expect(disabled.Reserve("fake", secret)).To(BeZero())
+		}
+	})
+})
+
+var _ = Describe("retryAfter", func() {
+	now := time.Date(2022, 4, 15, 5, 20, 0, 0, time.UTC)
+
+	It("parses delays in seconds", func() {
+		Expect(retryAfter("120", now)).To(Equal(2 * time.Minute))
+	})
+
+	It("parses HTTP dates", func() {
+		Expect(retryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now)).To(Equal(90 * time.Second))
+	})
+
+	It("falls back to the default and waits at least a second", func() {
+		Expect(retryAfter("", now)).To(Equal(DefaultRetryAfter))
+		Expect(retryAfter("0", now)).To(Equal(time.Second))
+		Expect(retryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now)).To(Equal(time.Second))
+	})
+})
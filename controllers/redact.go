@@ -0,0 +1,69 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces secret values in errors, logs, events and conditions
+const Redacted = "[REDACTED]"
+
+// sensitiveQueryParam matches the value of URL query parameters that carry API tokens, e.g. OpenWeatherMap's
+// appid, as found in the message of a *url.Error
+var sensitiveQueryParam = regexp.MustCompile(`(?i)\b(appid|apikey|api_key|access_token|token|key)=[^&\s"']+`)
+
+// redactedError is an error whose message has secret values replaced. Unwrap returns the original error so
+// it can still be classified with errors.As, but its message must not be used.
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redact returns err with the values of sensitive URL query parameters and every occurrence of secrets
+// replaced in its message, or err itself when there is nothing to replace
+func redact(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	redacted := redactString(msg, secrets...)
+	if redacted == msg {
+		return err
+	}
+	return &redactedError{err: err, msg: redacted}
+}
+
+// redactString replaces the values of sensitive URL query parameters and every occurrence of secrets in s
+func redactString(s string, secrets ...string) string {
+	s = sensitiveQueryParam.ReplaceAllString(s, "${1}="+Redacted)
+	for _, secret := range secrets {
+		if secret = strings.TrimSpace(secret); len(secret) > 0 {
+			s = strings.ReplaceAll(s, secret, Redacted)
+		}
+	}
+	return s
+}
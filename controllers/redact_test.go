@@ -0,0 +1,159 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	weatherv1 "alsup/api/v1"
+)
+
+var _ = Describe("Token redaction", func() {
+	const token = "s3cr3t-owm-token"
+
+	It("redacts sensitive query parameters and secret values", func() {
+		err := &url.Error{Op: "Get", URL: "https://api.openweathermap.org/data/2.5/weather?appid=" + token + "&lat=1", Err: errors.New("connection refused")}
+		Expect(redact(err).Error()).To(Equal(`Get "https://api.openweathermap.org/data/2.5/weather?appid=[REDACTED]&lat=1": connection refused`))
+		Expect(redactString("token "+token+" rejected", token)).To(Equal("token [REDACTED] rejected"))
+		Expect(redactString("https://hooks.example.com/?key=abc def")).To(Equal("https://hooks.example.com/?key=[REDACTED] def"))
+	})
+
+	It("keeps the redacted error classifiable", func() {
+		err := redact(fmt.Errorf("wrapped: %w", context.DeadlineExceeded), "wrapped")
+		Expect(err.Error()).To(Equal("[REDACTED]: context deadline exceeded"))
+		Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+
+		plain := errors.New("nothing to hide")
+		Expect(redact(plain, token)).To(BeIdenticalTo(plain))
+		Expect(redact(nil, token)).To(BeNil())
+	})
+
+	Describe("reconciling with a failing OpenWeatherMap provider", func() {
+		var (
+			ctx      context.Context
+			logs     *bytes.Buffer
+			key      types.NamespacedName
+			weather  *weatherv1.Weather
+			provider *OpenWeatherMapProvider
+			server   *httptest.Server
+		)
+
+		BeforeEach(func() {
+			logs = &bytes.Buffer{}
+			ctx = log.IntoContext(context.Background(), zap.New(zap.WriteTo(logs), zap.UseDevMode(true)))
+			key = types.NamespacedName{Namespace: "default", Name: "sample"}
+			weather = newTestWeather()
+			weather.Spec.Provider = weatherv1.ProviderOpenWeatherMap
+			weather.Spec.Forecast = &weatherv1.ForecastSpec{Periods: 2}
+			weather.Spec.Alerts = true
+			weather.Spec.AirQuality = true
+
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(openWeatherMapSample))
+			}))
+			// a closed server, so requests fail with a *url.Error quoting the request URL
+			closed := httptest.NewServer(http.NotFoundHandler())
+			closed.Close()
+			provider = NewOpenWeatherMapProvider()
+			provider.BaseUrl = server.URL
+			provider.ForecastUrl = closed.URL + "/forecast"
+			provider.OneCallUrl = closed.URL + "/onecall"
+			provider.AirPollutionUrl = closed.URL + "/air_pollution"
+		})
+
+		AfterEach(func() {
+			server.Close()
+			forgetWeatherMetrics(key)
+		})
+
+		// recordedOutput collects everything the reconcile exposed: logs, events, the status and its returned error
+		recordedOutput := func(r *WeatherReconciler, recorder *record.FakeRecorder, reconcileErr error) string {
+			var out strings.Builder
+			out.WriteString(logs.String())
+			for len(recorder.Events) > 0 {
+				out.WriteString(<-recorder.Events + "\n")
+			}
+			Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+			status, err := json.Marshal(weather.Status)
+			Expect(err).NotTo(HaveOccurred())
+			out.Write(status)
+			if reconcileErr != nil {
+				out.WriteString(reconcileErr.Error())
+			}
+			families, err := metrics.Registry.Gather()
+			Expect(err).NotTo(HaveOccurred())
+			for _, family := range families {
+				for _, metric := range family.GetMetric() {
+					for _, label := range metric.GetLabel() {
+						out.WriteString(label.GetValue() + "\n")
+					}
+				}
+			}
+			return out.String()
+		}
+
+		It("does not expose the token when the observation fails", func() {
+			server.Close()
+			secret := newTestSecret()
+			secret.Data["token"] = []byte(token)
+			r, recorder := newTestReconciler(provider, weather, secret)
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+			Expect(err).To(HaveOccurred())
+
+			out := recordedOutput(r, recorder, err)
+			Expect(out).To(ContainSubstring("appid=[REDACTED]"))
+			Expect(out).NotTo(ContainSubstring(token))
+		})
+
+		It("does not expose the token when forecasts, alerts or air quality fail", func() {
+			secret := newTestSecret()
+			secret.Data["token"] = []byte(token)
+			r, recorder := newTestReconciler(provider, weather, secret)
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+
+			out := recordedOutput(r, recorder, err)
+			Expect(out).To(ContainSubstring("Unable to fetch air quality"))
+			Expect(out).To(ContainSubstring("/air_pollution?appid=[REDACTED]"))
+			Expect(out).NotTo(ContainSubstring(token))
+		})
+	})
+
+	It("does not expose notification URLs or HMAC keys", func() {
+		target := "http://127.0.0.1:1/hooks/" + token
+		err := redact(fmt.Errorf("dial %s: connection refused", target), target, "hmac-key")
+		Expect(err.Error()).NotTo(ContainSubstring(token))
+		Expect(err.Error()).To(Equal("dial [REDACTED]: connection refused"))
+	})
+})
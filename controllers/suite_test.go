@@ -30,6 +30,7 @@ import (
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	weatherv1 "alsup/api/v1"
 	weatherv1beta1 "alsup/api/v1beta1"
 	//+kubebuilder:scaffold:imports
 )
@@ -65,6 +66,9 @@ var _ = BeforeSuite(func() {
 	err = weatherv1beta1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
 
+	err = weatherv1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
+
 	//+kubebuilder:scaffold:scheme
 
 	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
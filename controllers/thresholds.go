@@ -0,0 +1,135 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	weatherv1 "alsup/api/v1"
+)
+
+// statusMeasurement returns the status measurement a threshold watches, nil when it was not reported
+func statusMeasurement(status *weatherv1.WeatherStatus, name string) *weatherv1.Measurement {
+	switch name {
+	case weatherv1.MeasurementTemp:
+		return status.Temp
+	case weatherv1.MeasurementPressure:
+		return status.Pressure
+	case weatherv1.MeasurementHumidity:
+		return status.Humidity
+	case weatherv1.MeasurementWindSpeed:
+		return status.WindSpeed
+	case weatherv1.MeasurementWindGust:
+		return status.WindGust
+	case weatherv1.MeasurementAQI:
+		return airQualityIndex(status)
+	}
+	if aq := status.AirQuality; aq != nil {
+		switch name {
+		case weatherv1.MeasurementCO:
+			return aq.CO
+		case weatherv1.MeasurementNO2:
+			return aq.NO2
+		case weatherv1.MeasurementO3:
+			return aq.O3
+		case weatherv1.MeasurementSO2:
+			return aq.SO2
+		case weatherv1.MeasurementPM25:
+			return aq.PM25
+		case weatherv1.MeasurementPM10:
+			return aq.PM10
+		}
+	}
+	return nil
+}
+
+// thresholdBreached reports whether a measurement breaches a threshold, given whether it was breached before
+func thresholdBreached(threshold weatherv1.Threshold, value float64, wasBreached bool) bool {
+	limit := threshold.Value
+	if threshold.Operator == weatherv1.ThresholdBelow {
+		if wasBreached {
+			limit += threshold.Hysteresis
+		}
+		return value < limit
+	}
+	if wasBreached {
+		limit -= threshold.Hysteresis
+	}
+	return value > limit
+}
+
+// evaluateThresholds evaluates spec.thresholds against the status measurements, records the breached
+// thresholds and the ThresholdBreached condition, and returns a Warning event for every threshold that
+// went into or out of breach. Thresholds on measurements the provider did not report keep their state.
+func evaluateThresholds(weather *weatherv1.Weather) []statusEvent {
+	if len(weather.Spec.Thresholds) == 0 {
+		weather.Status.BreachedThresholds = nil
+		meta.RemoveStatusCondition(&weather.Status.Conditions, weatherv1.ConditionThresholdBreached)
+		return nil
+	}
+
+	wasBreached := map[string]bool{}
+	for _, name := range weather.Status.BreachedThresholds {
+		wasBreached[name] = true
+	}
+	var breached []string
+	var events []statusEvent
+	for _, threshold := range weather.Spec.Thresholds {
+		m := statusMeasurement(&weather.Status, threshold.Measurement)
+		if m == nil {
+			if wasBreached[threshold.Name] {
+				breached = append(breached, threshold.Name)
+			}
+			continue
+		}
+		isBreached := thresholdBreached(threshold, m.Value, wasBreached[threshold.Name])
+		if isBreached {
+			breached = append(breached, threshold.Name)
+		}
+		switch {
+		case isBreached && !wasBreached[threshold.Name]:
+			events = append(events, statusEvent{eventType: corev1.EventTypeWarning, reason: "ThresholdBreached",
+				message: fmt.Sprintf("Threshold '%s' breached: %s is %s", threshold.Name, threshold.Measurement, measurementString(m))})
+		case !isBreached && wasBreached[threshold.Name]:
+			events = append(events, statusEvent{eventType: corev1.EventTypeWarning, reason: "ThresholdCleared",
+				message: fmt.Sprintf("Threshold '%s' cleared: %s is %s", threshold.Name, threshold.Measurement, measurementString(m))})
+		}
+	}
+	weather.Status.BreachedThresholds = breached
+
+	if len(breached) == 0 {
+		setCondition(weather, weatherv1.ConditionThresholdBreached, metav1.ConditionFalse, ReasonWithinThresholds,
+			"No thresholds breached")
+		return events
+	}
+	setCondition(weather, weatherv1.ConditionThresholdBreached, metav1.ConditionTrue, ReasonThresholdBreached,
+		fmt.Sprintf("Breached thresholds: %s", strings.Join(breached, ", ")))
+	return events
+}
+
+// measurementString formats a measurement for messages, e.g. "61.5 degF", or "4" when it has no unit
+func measurementString(m *weatherv1.Measurement) string {
+	if len(m.Unit) == 0 {
+		return fmt.Sprintf("%g", m.Value)
+	}
+	return fmt.Sprintf("%g %s", m.Value, m.Unit)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	weatherv1 "alsup/api/v1"
+)
+
+var _ = Describe("Weather thresholds", func() {
+	heat := weatherv1.Threshold{Name: "heat", Measurement: weatherv1.MeasurementTemp, Operator: weatherv1.ThresholdAbove, Value: 95, Hysteresis: 2}
+	frost := weatherv1.Threshold{Name: "frost", Measurement: weatherv1.MeasurementTemp, Operator: weatherv1.ThresholdBelow, Value: 32, Hysteresis: 1}
+
+	table.DescribeTable("thresholdBreached applies hysteresis to breached thresholds",
+		func(threshold weatherv1.Threshold, value float64, wasBreached bool, expected bool) {
+			Expect(thresholdBreached(threshold, value, wasBreached)).To(Equal(expected))
+		},
+		table.Entry("above, not breached", heat, 95.0, false, false),
+		table.Entry("above, breaching", heat, 95.5, false, true),
+		table.Entry("above, within hysteresis", heat, 93.5, true, true),
+		table.Entry("above, clearing", heat, 92.5, true, false),
+		table.Entry("below, breaching", frost, 31.5, false, true),
+		table.Entry("below, within hysteresis", frost, 32.5, true, true),
+		table.Entry("below, clearing", frost, 33.5, true, false),
+	)
+
+	var (
+		ctx      context.Context
+		provider *fakeProvider
+		key      types.NamespacedName
+		weather  *weatherv1.Weather
+		events   chan string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "sample"}
+		provider = &fakeProvider{obs: Observation{Time: time.Unix(1650000000, 0), Temp: 96, WindGust: float64Ptr(12)}}
+		weather = newTestWeather()
+		weather.Spec.Thresholds = []weatherv1.Threshold{heat, {
+			Name: "gusts", Measurement: weatherv1.MeasurementWindGust, Operator: weatherv1.ThresholdAbove, Value: 40,
+		}}
+	})
+
+	AfterEach(func() {
+		forgetWeatherMetrics(key)
+	})
+
+	reconcile := func() *weatherv1.Weather {
+		r, recorder := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		events = recorder.Events
+		return weather
+	}
+
+	It("reports a breach with the ThresholdBreached condition and a Warning event", func() {
+		weather = reconcile()
+		Expect(events).To(Receive(Equal("Warning ThresholdBreached Threshold 'heat' breached: temp is 96 degF")))
+		Expect(weather.Status.BreachedThresholds).To(Equal([]string{"heat"}))
+		cond := meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionThresholdBreached)
+		Expect(cond.Status).To(BeEquivalentTo("True"))
+		Expect(cond.Message).To(Equal("Breached thresholds: heat"))
+	})
+
+	It("does not clear a breach within the hysteresis", func() {
+		weather.Status.BreachedThresholds = []string{"heat"}
+		provider.obs.Temp = 94
+		weather = reconcile()
+		Expect(events).NotTo(Receive(ContainSubstring("Threshold")))
+		Expect(weather.Status.BreachedThresholds).To(Equal([]string{"heat"}))
+	})
+
+	It("emits a Warning event when a breach clears", func() {
+		weather.Status.BreachedThresholds = []string{"heat"}
+		provider.obs.Temp = 90
+		weather = reconcile()
+		Expect(events).To(Receive(Equal("Warning ThresholdCleared Threshold 'heat' cleared: temp is 90 degF")))
+		Expect(weather.Status.BreachedThresholds).To(BeEmpty())
+		Expect(meta.IsStatusConditionFalse(weather.Status.Conditions, weatherv1.ConditionThresholdBreached)).To(BeTrue())
+	})
+})
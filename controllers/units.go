@@ -0,0 +1,70 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"math"
+
+	weatherv1 "alsup/api/v1"
+)
+
+// Unit systems, named after the OpenWeatherMap `units` parameter.
+// Pressure is always reported in hPa and humidity in percent.
+const (
+	// UnitsImperial reports temperature in Fahrenheit and wind speed in mph
+	UnitsImperial = weatherv1.UnitsImperial
+	// UnitsMetric reports temperature in Celsius and wind speed in m/s
+	UnitsMetric = weatherv1.UnitsMetric
+	// UnitsStandard reports temperature in Kelvin and wind speed in m/s
+	UnitsStandard = weatherv1.UnitsStandard
+)
+
+const DefaultUnits = weatherv1.DefaultUnits
+
+// fromCelsius converts a temperature in Celsius into the given unit system
+func fromCelsius(c float64, units string) float64 {
+	switch units {
+	case UnitsMetric:
+		return c
+	case UnitsStandard:
+		return c + 273.15
+	default:
+		return c*9/5 + 32
+	}
+}
+
+// fromMetersPerSecond converts a speed in m/s into the given unit system
+func fromMetersPerSecond(ms float64, units string) float64 {
+	switch units {
+	case UnitsMetric, UnitsStandard:
+		return ms
+	default:
+		return ms * 2.236936
+	}
+}
+
+// cardinalDirections are the 16 compass points, clockwise from north
+var cardinalDirections = []string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
+
+// cardinal returns the 16-point compass direction nearest to a direction in degrees
+func cardinal(degrees float64) string {
+	i := int(math.Round(math.Mod(degrees, 360)/22.5)) % len(cardinalDirections)
+	if i < 0 {
+		i += len(cardinalDirections)
+	}
+	return cardinalDirections[i]
+}
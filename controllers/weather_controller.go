@@ -18,86 +18,77 @@ package controllers
 
 import (
 	"context"
-	goerrs "errors"
+	"errors"
 	"fmt"
-	"io"
-	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/util/json"
+	"hash/fnv"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/tools/record"
-	"net/http"
-	"strconv"
+	"math"
 	"strings"
+	"sync"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
-	weatherv1beta1 "alsup/api/v1beta1"
+	weatherv1 "alsup/api/v1"
 )
 
-const WeatherUrl = "https://api.openweathermap.org/data/2.5/weather"
-const UnitFormat = "imperial"
 const WeatherAPITimeout = 10 * time.Second
-const DefaultRefreshPeriod = "5m"
+const DefaultRefreshPeriod = weatherv1.DefaultRefreshPeriod
+const DefaultSecretKey = weatherv1.DefaultSecretKey
+
+// DefaultRefreshJitter is the default WeatherReconciler.RefreshJitter
+const DefaultRefreshJitter = 0.1
+
+// SecretRefNameField indexes Weathers by the names of the secrets in spec.secretRef and spec.providers
+const SecretRefNameField = "spec.secretRef.name"
 
 // WeatherReconciler reconciles a Weather object
 type WeatherReconciler struct {
 	Client   client.Client
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
+	// Providers maps spec.provider and spec.providers names to implementations (defaults to DefaultProviders)
+	Providers map[string]WeatherProvider
+	// SecretNamespaces are the namespaces, other than its own, a Weather may read its secret from ("*" allows any)
+	SecretNamespaces []string
+	// Cache shares observations between Weathers for the same place (nil disables caching)
+	Cache *ObservationCache
+	// RateLimiter limits the calls made to each provider and with each secret (nil disables rate limiting)
+	RateLimiter *RateLimiter
+	// Notifier delivers spec.notify notifications in the background (nil disables notifications)
+	Notifier *Notifier
+	// RefreshJitter delays the first fetch of each Weather, and the first after a spec change, by a stable
+	// fraction, up to RefreshJitter, of its refreshPeriod, so Weathers created together do not all fetch at once
+	RefreshJitter float64
+
+	mu sync.Mutex
+	// firstFetches holds when the first fetch of each Weather's current generation is due
+	firstFetches map[types.NamespacedName]firstFetch
 }
 
-type OpenWeatherMapResponse struct {
-	Coord struct {
-		Lon float64 `json:"lon"`
-		Lat float64 `json:"lat"`
-	} `json:"coord"`
-	Weather []struct {
-		Id          int    `json:"id"`
-		Main        string `json:"main"`
-		Description string `json:"description"`
-		Icon        string `json:"icon"`
-	} `json:"weather"`
-	Base string `json:"base"`
-	Main struct {
-		Temp      float64 `json:"temp"`
-		FeelsLike float64 `json:"feels_like"`
-		TempMin   float64 `json:"temp_min"`
-		TempMax   float64 `json:"temp_max"`
-		Pressure  int64   `json:"pressure"`
-		Humidity  int64   `json:"humidity"`
-	} `json:"main"`
-	Visibility uint32 `json:"visibility"`
-	Wind       struct {
-		Speed float64 `json:"speed"`
-		Deg   uint16  `json:"deg"`
-		Gust  float64 `json:"gust"`
-	} `json:"wind"`
-	Clouds struct {
-		All uint16 `json:"all"`
-	} `json:"clouds"`
-	DateTime int64 `json:"dt"`
-	Sys      struct {
-		Type    uint16  `json:"type"`
-		Id      uint32  `json:"id"`
-		Message float64 `json:"message"`
-		Country string  `json:"country"`
-		Sumrise uint64  `json:"sunrise"`
-		Sunset  uint64  `json:"sunset"`
-	} `json:"sys"`
-	Timezone int    `json:"timezone"`
-	Id       uint32 `json:"id"`
-	Name     string `json:"name"`
-	Cod      uint16 `json:"cod"`
+// firstFetch is when the first fetch of a generation of a Weather is due
+type firstFetch struct {
+	uid        types.UID
+	generation int64
+	due        time.Time
 }
 
 //+kubebuilder:rbac:groups=weather.alsup,resources=weathers,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=weather.alsup,resources=weathers/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=weather.alsup,resources=weathers/finalizers,verbs=update
 //+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 // Reconcile For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.11.0/pkg/reconcile
@@ -106,12 +97,17 @@ func (r *WeatherReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	logger.Info("Reconciling weather")
 
 	// get the weather spec
-	weather := &weatherv1beta1.Weather{}
+	weather := &weatherv1.Weather{}
 	err := r.Client.Get(ctx, req.NamespacedName, weather)
 	if err != nil {
-		if errors.IsNotFound(err) {
+		if apierrors.IsNotFound(err) {
 			// instance was likely deleted, between Reconcile and here
 			logger.Info("weather instance not found. probably deleted")
+			forgetWeatherMetrics(req.NamespacedName)
+			r.forgetFirstFetch(req.NamespacedName)
+			if r.Notifier != nil {
+				r.Notifier.forget(req.NamespacedName)
+			}
 			return ctrl.Result{}, nil
 		}
 		logger.Error(err, "failed to get weather instance")
@@ -119,131 +115,132 @@ func (r *WeatherReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	}
 	logger.Info(fmt.Sprintf("got weather spec for lat: %s, lon: %s", weather.Spec.Lat, weather.Spec.Lon))
 
-	// get the referenced secret spec (need to get the OpenWeatherAPI token)
-	secret := &corev1.Secret{}
-	secretKey := client.ObjectKey{Namespace: weather.Namespace, Name: weather.Spec.SecretRef.Name}
-	err = r.Client.Get(ctx, secretKey, secret)
-	if err != nil {
-		errMsg := fmt.Sprintf("Cannot find secret '%s'", weather.Spec.SecretRef.Name)
-		logger.Error(err, errMsg)
-		r.Recorder.Event(weather, "Failure", "Secret", errMsg)
-		return ctrl.Result{}, err
+	// record the notifications delivered since the last reconcile, which is usually what triggered this one
+	if r.recordNotifications(ctx, weather) {
+		err = r.Client.Status().Update(ctx, weather)
+		if err != nil {
+			logger.Error(err, "Unable to record notification outcomes")
+			return ctrl.Result{}, err
+		}
 	}
 
-	// build the OpenWeatherMap API URL
-	secretBytes, ok := secret.Data["token"]
-	if !ok {
-		errMsg := fmt.Sprintf("Secret '%s' does not have a 'token' attribute", secretKey)
-		logger.Error(nil, errMsg)
-		r.Recorder.Event(weather, "Failure", "Secret", errMsg)
-		return ctrl.Result{}, err
+	// skip the upstream call when the last fetch is still fresh, e.g. for metadata-only changes or resyncs
+	refreshPeriod := r.refreshPeriod(ctx, weather)
+	if wait := r.untilNextFetch(weather, refreshPeriod); wait > 0 {
+		logger.Info("Weather is up to date, skipping fetch", "NextRun", wait.String())
+		return ctrl.Result{RequeueAfter: wait}, nil
 	}
-	apiToken := string(secretBytes)
-	url := fmt.Sprintf("%s?lat=%s&lon=%s&units=%s&appid=%s", WeatherUrl, weather.Spec.Lat, weather.Spec.Lon, UnitFormat, apiToken) //logger.Info(fmt.Sprintf("URL: %s", url))
-	httpClient := http.Client{Timeout: WeatherAPITimeout}
-	resp, err := httpClient.Get(url)
-	if err != nil {
-		errMsg := "Unable to query weather API"
-		logger.Error(err, errMsg)
-		r.Recorder.Event(weather, "Failure", "WeatherAPI", errMsg)
-		return ctrl.Result{}, err
+
+	// resolve the configured weather providers and their API tokens; a provider that cannot be resolved is
+	// skipped, the refresh only fails when none of them can be
+	var chain []*resolvedProvider
+	var failures []*providerFailure
+	for i, ref := range weather.Spec.ProviderChain() {
+		resolved, failure := r.resolveProvider(ctx, weather, i, ref)
+		if failure != nil {
+			failures = append(failures, failure)
+			continue
+		}
+		chain = append(chain, resolved)
 	}
-	if resp.StatusCode != 200 {
-		errMsg := fmt.Sprintf("WeatherAPI returned status-code: %d", resp.StatusCode)
-		err = goerrs.New(errMsg)
-		logger.Error(err, errMsg)
-		r.Recorder.Event(weather, "Failure", "WeatherAPI", errMsg)
-		return ctrl.Result{}, err
+	if len(chain) == 0 {
+		failure := failures[0]
+		r.reportFailure(ctx, weather, failure.condType, failure.reason, failure.eventReason, failure.msg)
+		return ctrl.Result{}, failure.err
 	}
-	//goland:noinspection GoUnhandledErrorResult
-	defer resp.Body.Close()
-
-	// read the OpenWeatherMap response data
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		errMsg := "Unable to read JSON weather response"
-		logger.Error(err, errMsg)
-		r.Recorder.Event(weather, "Failure", "WeatherAPI", errMsg)
-		return ctrl.Result{}, err
+	for _, failure := range failures {
+		r.Recorder.Event(weather, corev1.EventTypeWarning, failure.eventReason, fmt.Sprintf("%s, skipping the provider", failure.msg))
 	}
+	setSecretResolved(weather, chain, failures)
 
-	// parse the OpenWeatherMap response
-	var jResponse OpenWeatherMapResponse
-	err = json.Unmarshal(data, &jResponse)
-	if err != nil {
-		errMsg := "Unable to parse JSON response into OpenWeatherMapResponse"
-		logger.Error(err, errMsg)
-		r.Recorder.Event(weather, "Failure", "WeatherAPI", errMsg)
-		return ctrl.Result{}, err
+	// failures retrying cannot fix are not retried until the spec or a secret changes
+	secretVersion := secretResourceVersion(chain)
+	if parked(weather, secretVersion) {
+		logger.Info("Weather refresh failed permanently, waiting for the spec or secret to change",
+			"reason", meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionReady).Reason)
+		return ctrl.Result{}, nil
 	}
+	weather.Status.SecretResourceVersion = secretVersion
 
-	// update the weather status
-	var dataChanged []string
-	sTemp := fmt.Sprintf("%.2f", jResponse.Main.Temp)
-	if weather.Status.Temp != sTemp {
-		attrib := "Temp"
-		prevTemp, err := strconv.ParseFloat(weather.Status.Temp, 64)
+	// query the weather providers in order, failing over to the next one while a provider is unavailable
+	units := weather.Spec.Units
+	if len(units) == 0 {
+		units = DefaultUnits
+	}
+	tokens := chainTokens(chain)
+	var active *resolvedProvider
+	var limited *rateLimitedProvider
+	var obsReq ObservationRequest
+	var obs *Observation
+	for i, candidate := range chain {
+		limited = &rateLimitedProvider{WeatherProvider: candidate.provider, limiter: r.RateLimiter, secret: candidate.secret}
+		lat, lon, err := r.coordinates(ctx, weather, limited, candidate.token)
+		if errors.Is(err, errGeocodingNotSupported) {
+			errMsg := fmt.Sprintf("Provider '%s' does not support geocoding, set spec.lat and spec.lon instead", candidate.name)
+			logger.Error(nil, errMsg)
+			r.reportFailure(ctx, weather, weatherv1.ConditionProviderReachable, ReasonGeocodingFailed, "Geocoding", errMsg)
+			return ctrl.Result{}, nil
+		}
+		errMsg := "Unable to resolve spec.location"
 		if err == nil {
-			if prevTemp < jResponse.Main.Temp {
-				attrib += "+"
-			} else {
-				attrib += "-"
+			obsReq = ObservationRequest{
+				Lat:   lat,
+				Lon:   lon,
+				Units: units,
+				Token: candidate.token,
 			}
+			obs, err = r.Cache.CurrentConditions(ctx, limited, obsReq)
+			errMsg = "Unable to query weather API"
 		}
-		dataChanged = append(dataChanged, attrib)
-		weather.Status.Temp = sTemp
-	}
-	if weather.Status.Pressure != jResponse.Main.Pressure {
-		attrib := "Pressure"
-		if weather.Status.Pressure < jResponse.Main.Pressure {
-			attrib += "+"
-		} else {
-			attrib += "-"
+		if err == nil {
+			active = candidate
+			break
 		}
-		dataChanged = append(dataChanged, attrib)
-		weather.Status.Pressure = jResponse.Main.Pressure
-	}
-	if weather.Status.Humidity != jResponse.Main.Humidity {
-		attrib := "Humidity"
-		if weather.Status.Humidity < jResponse.Main.Humidity {
-			attrib += "+"
-		} else {
-			attrib += "-"
+		if i < len(chain)-1 && failsOver(err) {
+			logger.Info("Provider unavailable, failing over to the next provider",
+				"provider", candidate.name, "next", chain[i+1].name, "error", redact(err, tokens...).Error())
+			continue
 		}
-		dataChanged = append(dataChanged, attrib)
-		weather.Status.Humidity = jResponse.Main.Humidity
+		return r.reportProviderError(ctx, weather, candidate.name, errMsg, err, tokens, refreshPeriod)
 	}
-	sWindSpeed := fmt.Sprintf("%.2f", jResponse.Wind.Speed)
-	if weather.Status.WindSpeed != sWindSpeed {
-		attrib := "WindSpeed"
-		prevVal, err := strconv.ParseFloat(weather.Status.WindSpeed, 64)
-		if err == nil {
-			if prevVal < jResponse.Wind.Speed {
-				attrib += "+"
-			} else {
-				attrib += "-"
-			}
+
+	// update the weather status, keeping the previous reading of measurements the provider did not report
+	var dataChanged []string
+	for _, m := range []struct {
+		name    string
+		current **weatherv1.Measurement
+		value   *float64
+		unit    string
+	}{
+		{"Temp", &weather.Status.Temp, &obs.Temp, weatherv1.TemperatureUnit(units)},
+		{"Pressure", &weather.Status.Pressure, int64ToFloat(obs.Pressure), weatherv1.UnitHectopascal},
+		{"Humidity", &weather.Status.Humidity, int64ToFloat(obs.Humidity), weatherv1.UnitPercent},
+		{"WindSpeed", &weather.Status.WindSpeed, obs.WindSpeed, weatherv1.SpeedUnit(units)},
+		{"WindGust", &weather.Status.WindGust, obs.WindGust, weatherv1.SpeedUnit(units)},
+	} {
+		if m.value == nil {
+			continue
 		}
-		dataChanged = append(dataChanged, attrib)
-		weather.Status.WindSpeed = sWindSpeed
-	}
-	sWindGust := fmt.Sprintf("%.2f", jResponse.Wind.Gust)
-	if weather.Status.WindGust != sWindGust {
-		attrib := "WindGust"
-		prevVal, err := strconv.ParseFloat(weather.Status.WindGust, 64)
-		if err == nil {
-			if prevVal < jResponse.Wind.Gust {
-				attrib += "+"
-			} else {
-				attrib += "-"
-			}
+		if attrib, changed := updateMeasurement(m.name, m.current, *m.value, m.unit); changed {
+			dataChanged = append(dataChanged, attrib)
 		}
-		dataChanged = append(dataChanged, attrib)
-		weather.Status.WindGust = sWindGust
 	}
-	weather.Status.RefreshTime = time.Unix(jResponse.DateTime, 0).String()
-	weather.Status.CountryCode = jResponse.Sys.Country
-	weather.Status.LocationName = jResponse.Name
+	refreshTime := metav1.NewTime(obs.Time)
+	weather.Status.RefreshTime = &refreshTime
+	fetchTime := metav1.Now()
+	weather.Status.LastFetchTime = &fetchTime
+	weather.Status.Units = units
+	setRefreshed(weather, active.name)
+	events := setActiveProvider(weather, active.name, active.index == 0)
+	weather.Status.CountryCode = obs.CountryCode
+	weather.Status.LocationName = obs.LocationName
+	updateDetails(&weather.Status, obs, units)
+	recordHistory(weather)
+	r.updateForecast(ctx, weather, active.name, limited, obsReq)
+	events = append(events, r.updateAlerts(ctx, weather, active.name, limited, obsReq)...)
+	r.updateAirQuality(ctx, weather, active.name, limited, obsReq)
+	events = append(events, evaluateThresholds(weather)...)
+	pruneNotifications(weather)
 	logger.Info(fmt.Sprintf("got weather response for: %s, %s", weather.Status.LocationName, weather.Status.CountryCode))
 
 	// update the kubernetes status
@@ -252,28 +249,355 @@ func (r *WeatherReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		logger.Error(err, "Unable to post update to weather")
 		return ctrl.Result{}, err
 	}
+	recordWeatherMetrics(weather)
+	r.forgetFirstFetch(req.NamespacedName)
 
-	// record an event if data has changed
+	// report the status changes once they are written, so a failed update does not report them twice
+	for _, e := range events {
+		r.Recorder.Event(weather, e.eventType, e.reason, e.message)
+	}
 	if len(dataChanged) > 0 {
 		msg := fmt.Sprintf("Weather changed. [%s]", strings.Join(dataChanged, ", "))
 		r.Recorder.Event(weather, corev1.EventTypeNormal, "Updated", msg)
 	}
+	r.notify(ctx, weather, dataChanged)
 
-	// schedule the next reconcile
+	// schedule the next fetch from this one, so the schedule does not drift by the reconcile duration
+	nextRun := r.untilNextFetch(weather, refreshPeriod)
+	logger.Info("Reconcile done", "Temp", obs.Temp, "NextRun", nextRun.String())
+	return ctrl.Result{RequeueAfter: nextRun}, nil
+}
+
+// refreshPeriod returns the parsed spec.refreshPeriod, falling back to the default when it is invalid
+func (r *WeatherReconciler) refreshPeriod(ctx context.Context, weather *weatherv1.Weather) time.Duration {
 	refreshPeriod := DefaultRefreshPeriod
 	if len(weather.Spec.RefreshPeriod) > 0 {
 		refreshPeriod = weather.Spec.RefreshPeriod
 	}
-	nextRun, _ := time.ParseDuration(refreshPeriod)
-	logger.Info("Reconcile done", "Temp", jResponse.Main.Temp, "NextRun", nextRun.String())
-	return ctrl.Result{RequeueAfter: nextRun}, nil
+	period, err := time.ParseDuration(refreshPeriod)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Invalid refreshPeriod, using the default", "refreshPeriod", refreshPeriod)
+		period, _ = time.ParseDuration(DefaultRefreshPeriod)
+	}
+	return period
+}
+
+// untilNextFetch returns how long until the weather is due a fetch, from status.lastFetchTime + refreshPeriod.
+// The first fetch, and the first after a spec change, is delayed by the weather's jitter instead, which offsets
+// the phase of all later fetches. A fetch is due now (0) when the last refresh failed.
+func (r *WeatherReconciler) untilNextFetch(weather *weatherv1.Weather, refreshPeriod time.Duration) time.Duration {
+	if weather.Status.LastFetchTime == nil || weather.Status.ObservedGeneration != weather.Generation {
+		return r.untilFirstFetch(weather, refreshPeriod)
+	}
+	if !meta.IsStatusConditionTrue(weather.Status.Conditions, weatherv1.ConditionReady) {
+		return 0
+	}
+	if wait := time.Until(weather.Status.LastFetchTime.Add(refreshPeriod)); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// untilFirstFetch returns how long until the first fetch of the weather's generation is due, the weather's jitter
+// after the generation was first reconciled
+func (r *WeatherReconciler) untilFirstFetch(weather *weatherv1.Weather, refreshPeriod time.Duration) time.Duration {
+	jitter := r.jitter(weather, refreshPeriod)
+	if jitter == 0 {
+		return 0
+	}
+	key := client.ObjectKeyFromObject(weather)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	first, found := r.firstFetches[key]
+	if !found || first.uid != weather.UID || first.generation != weather.Generation {
+		if r.firstFetches == nil {
+			r.firstFetches = map[types.NamespacedName]firstFetch{}
+		}
+		first = firstFetch{uid: weather.UID, generation: weather.Generation, due: time.Now().Add(jitter)}
+		r.firstFetches[key] = first
+	}
+	if wait := time.Until(first.due); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// forgetFirstFetch drops the first fetch scheduled for a weather, once it was fetched or deleted
+func (r *WeatherReconciler) forgetFirstFetch(key types.NamespacedName) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.firstFetches, key)
+}
+
+// jitter returns a stable delay between 0 and RefreshJitter * refreshPeriod, derived from the weather's identity
+func (r *WeatherReconciler) jitter(weather *weatherv1.Weather, refreshPeriod time.Duration) time.Duration {
+	if r.RefreshJitter <= 0 {
+		return 0
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(string(weather.UID) + "/" + weather.Namespace + "/" + weather.Name))
+	fraction := float64(h.Sum32()) / math.MaxUint32
+	return time.Duration(fraction * math.Min(r.RefreshJitter, 1) * float64(refreshPeriod))
+}
+
+// secretKeyFor returns the key of the secret referenced by secretRef, which defaults to the Weather's namespace
+func (r *WeatherReconciler) secretKeyFor(weather *weatherv1.Weather, secretRef *weatherv1.SecretRefSpec) client.ObjectKey {
+	namespace := secretRef.Namespace
+	if len(namespace) == 0 {
+		namespace = weather.Namespace
+	}
+	return client.ObjectKey{Namespace: namespace, Name: secretRef.Name}
+}
+
+// resolvedProvider is a provider of the chain together with the API token read for it
+type resolvedProvider struct {
+	// index is the position of the provider in the configured chain, 0 being the primary provider
+	index    int
+	name     string
+	provider WeatherProvider
+	secret   *corev1.Secret
+	token    string
+	// secretMsg describes where the token came from, for the SecretResolved condition
+	secretMsg string
+}
+
+// providerFailure describes why a provider of the chain could not be resolved
+type providerFailure struct {
+	condType    string
+	reason      string
+	eventReason string
+	msg         string
+	// err is returned by Reconcile when no provider of the chain can be resolved
+	err error
+}
+
+// resolveProvider looks up the provider at index of the chain and reads its API token. When that fails, the
+// failure is returned instead.
+func (r *WeatherReconciler) resolveProvider(ctx context.Context, weather *weatherv1.Weather, index int, ref weatherv1.ProviderRef) (*resolvedProvider, *providerFailure) {
+	logger := log.FromContext(ctx)
+	provider, ok := r.Providers[ref.Name]
+	if !ok {
+		errMsg := fmt.Sprintf("Unknown weather provider '%s'", ref.Name)
+		logger.Error(nil, errMsg)
+		return nil, &providerFailure{condType: weatherv1.ConditionProviderReachable, reason: ReasonUnknownProvider, eventReason: "Provider", msg: errMsg}
+	}
+	resolved := &resolvedProvider{index: index, name: ref.Name, provider: provider}
+	if !provider.RequiresToken() {
+		resolved.secretMsg = fmt.Sprintf("Provider '%s' does not require an API token", ref.Name)
+		return resolved, nil
+	}
+
+	// get the referenced secret spec (need to get the provider API token)
+	if ref.SecretRef == nil {
+		errMsg := fmt.Sprintf("Provider '%s' requires spec.secretRef", ref.Name)
+		logger.Error(nil, errMsg)
+		return nil, &providerFailure{condType: weatherv1.ConditionSecretResolved, reason: ReasonSecretRefMissing, eventReason: "Secret", msg: errMsg}
+	}
+	secretKey := r.secretKeyFor(weather, ref.SecretRef)
+	if !r.secretNamespaceAllowed(weather, secretKey.Namespace) {
+		errMsg := fmt.Sprintf("Secret namespace '%s' is not in the operator's allowed secret namespaces", secretKey.Namespace)
+		logger.Error(nil, errMsg)
+		return nil, &providerFailure{condType: weatherv1.ConditionSecretResolved, reason: ReasonSecretNamespaceNotAllowed, eventReason: "Secret", msg: errMsg}
+	}
+	secret := &corev1.Secret{}
+	if err := r.Client.Get(ctx, secretKey, secret); err != nil {
+		errMsg := fmt.Sprintf("Cannot find secret '%s'", secretKey)
+		logger.Error(err, errMsg)
+		failure := &providerFailure{condType: weatherv1.ConditionSecretResolved, reason: ReasonSecretNotFound, eventReason: "Secret", msg: errMsg}
+		// the secret watch requeues the weather once the secret is created
+		if !apierrors.IsNotFound(err) {
+			failure.err = err
+		}
+		return nil, failure
+	}
+	tokenKey := ref.SecretRef.Key
+	if len(tokenKey) == 0 {
+		tokenKey = DefaultSecretKey
+	}
+	secretBytes, found := secret.Data[tokenKey]
+	if !found {
+		errMsg := fmt.Sprintf("Secret '%s' does not have a '%s' attribute", secretKey, tokenKey)
+		logger.Error(nil, errMsg)
+		// the secret watch requeues the weather once the key is added
+		return nil, &providerFailure{condType: weatherv1.ConditionSecretResolved, reason: ReasonSecretKeyMissing, eventReason: "Secret", msg: errMsg}
+	}
+	resolved.secret = secret
+	resolved.token = string(secretBytes)
+	resolved.secretMsg = fmt.Sprintf("API token read from key '%s' of secret '%s'", tokenKey, secretKey)
+	return resolved, nil
+}
+
+// setSecretResolved records where the API tokens of the provider chain were read from. The condition is false
+// while the secret of a skipped provider cannot be read, even though the remaining providers are used.
+func setSecretResolved(weather *weatherv1.Weather, chain []*resolvedProvider, failures []*providerFailure) {
+	status, reason := metav1.ConditionTrue, ReasonTokenNotRequired
+	msgs := make([]string, 0, len(chain)+len(failures))
+	for _, resolved := range chain {
+		if resolved.secret != nil {
+			reason = ReasonSecretResolved
+		}
+		msgs = append(msgs, resolved.secretMsg)
+	}
+	for _, failure := range failures {
+		if failure.condType != weatherv1.ConditionSecretResolved {
+			continue
+		}
+		status, reason = metav1.ConditionFalse, failure.reason
+		msgs = append(msgs, failure.msg)
+	}
+	setCondition(weather, weatherv1.ConditionSecretResolved, status, reason, strings.Join(msgs, "; "))
+}
+
+// secretNamespaceAllowed reports whether the weather may read secrets from namespace
+func (r *WeatherReconciler) secretNamespaceAllowed(weather *weatherv1.Weather, namespace string) bool {
+	if namespace == weather.Namespace {
+		return true
+	}
+	for _, allowed := range r.SecretNamespaces {
+		if allowed == "*" || allowed == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// updateMeasurement stores a new reading (rounded to 2 decimals) in *current. When the reading changed it
+// returns the attribute name, suffixed with +/- when it is comparable to the previous reading.
+func updateMeasurement(name string, current **weatherv1.Measurement, value float64, unit string) (string, bool) {
+	value = round2(value)
+	prev := *current
+	if prev != nil && prev.Value == value && prev.Unit == unit {
+		return "", false
+	}
+	*current = &weatherv1.Measurement{Value: value, Unit: unit}
+
+	attrib := name
+	if prev != nil && prev.Unit == unit {
+		if prev.Value < value {
+			attrib += "+"
+		} else {
+			attrib += "-"
+		}
+	}
+	return attrib, true
+}
+
+// updateDetails stores the optional parts of an observation, clearing those the provider did not report
+func updateDetails(status *weatherv1.WeatherStatus, obs *Observation, units string) {
+	measurement := func(value *float64, unit string) *weatherv1.Measurement {
+		if value == nil {
+			return nil
+		}
+		return &weatherv1.Measurement{Value: round2(*value), Unit: unit}
+	}
+	precipitation := func(volume *PrecipitationVolume) *weatherv1.Precipitation {
+		if volume == nil {
+			return nil
+		}
+		return &weatherv1.Precipitation{
+			LastHour:   measurement(volume.LastHour, weatherv1.UnitMillimeters),
+			Last3Hours: measurement(volume.Last3Hours, weatherv1.UnitMillimeters),
+		}
+	}
+
+	status.FeelsLike = measurement(obs.FeelsLike, weatherv1.TemperatureUnit(units))
+	status.TempMin = measurement(obs.TempMin, weatherv1.TemperatureUnit(units))
+	status.TempMax = measurement(obs.TempMax, weatherv1.TemperatureUnit(units))
+	status.Visibility = measurement(obs.Visibility, weatherv1.UnitMeters)
+	status.CloudCover = measurement(obs.CloudCover, weatherv1.UnitPercent)
+	status.WindDirection = nil
+	if obs.WindDeg != nil {
+		status.WindDirection = &weatherv1.WindDirection{Degrees: int32(math.Round(*obs.WindDeg)), Cardinal: cardinal(*obs.WindDeg)}
+	}
+	status.Condition = nil
+	if obs.Condition != nil {
+		status.Condition = &weatherv1.WeatherCondition{
+			Id:          obs.Condition.Id,
+			Main:        obs.Condition.Main,
+			Description: obs.Condition.Description,
+			Icon:        obs.Condition.Icon,
+		}
+	}
+	status.Sunrise = optionalTime(obs.Sunrise)
+	status.Sunset = optionalTime(obs.Sunset)
+	status.Rain = precipitation(obs.Rain)
+	status.Snow = precipitation(obs.Snow)
+}
+
+// int64ToFloat converts an optional integer reading, returning nil when it was not reported
+func int64ToFloat(v *int64) *float64 {
+	if v == nil {
+		return nil
+	}
+	return float64Ptr(float64(*v))
+}
+
+// round2 rounds a reading to 2 decimals
+func round2(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// secretRefName is the SecretRefNameField indexer, returning the names of the secrets a Weather's providers reference
+func secretRefName(obj client.Object) []string {
+	weather := obj.(*weatherv1.Weather)
+	var names []string
+	seen := map[string]bool{}
+	for _, provider := range weather.Spec.ProviderChain() {
+		if provider.SecretRef == nil || seen[provider.SecretRef.Name] {
+			continue
+		}
+		seen[provider.SecretRef.Name] = true
+		names = append(names, provider.SecretRef.Name)
+	}
+	return names
+}
+
+// weathersForSecret maps a changed secret to reconcile requests for every Weather referencing it
+func (r *WeatherReconciler) weathersForSecret(secret client.Object) []reconcile.Request {
+	weathers := &weatherv1.WeatherList{}
+	err := r.Client.List(context.Background(), weathers, client.MatchingFields{SecretRefNameField: secret.GetName()})
+	if err != nil {
+		ctrl.Log.WithName("weather").Error(err, "Unable to list weathers referencing secret", "secret", client.ObjectKeyFromObject(secret))
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range weathers.Items {
+		weather := &weathers.Items[i]
+		// the index only holds the secret name, whose namespace defaults to the weather's own
+		for _, provider := range weather.Spec.ProviderChain() {
+			if provider.SecretRef != nil && r.secretKeyFor(weather, provider.SecretRef) == client.ObjectKeyFromObject(secret) {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(weather)})
+				break
+			}
+		}
+	}
+	return requests
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *WeatherReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.Recorder = mgr.GetEventRecorderFor("weather")
+	if r.Providers == nil {
+		r.Providers = DefaultProviders()
+	}
+	if r.Notifier == nil {
+		r.Notifier = NewNotifier(DefaultNotifyQueueSize)
+	}
+	// the notification workers run with the manager, and requeue weathers whose deliveries finished
+	err := mgr.Add(r.Notifier)
+	if err != nil {
+		return err
+	}
+
+	// index weathers by the secret they reference, so secret changes can be mapped back to them
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &weatherv1.Weather{}, SecretRefNameField, secretRefName)
+	if err != nil {
+		return err
+	}
 
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&weatherv1beta1.Weather{}).
+		For(&weatherv1.Weather{}).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.weathersForSecret)).
+		Watches(&source.Channel{Source: r.Notifier.Events()}, &handler.EnqueueRequestForObject{}).
 		Complete(r)
 }
@@ -0,0 +1,599 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	weatherv1 "alsup/api/v1"
+)
+
+// fakeProvider returns canned observations and records the requests it receives
+type fakeProvider struct {
+	obs       Observation
+	err       error
+	tokenless bool
+	requests  []ObservationRequest
+}
+
+func (p *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *fakeProvider) RequiresToken() bool {
+	return !p.tokenless
+}
+
+func (p *fakeProvider) CurrentConditions(_ context.Context, req ObservationRequest) (*Observation, error) {
+	p.requests = append(p.requests, req)
+	if p.err != nil {
+		return nil, p.err
+	}
+	obs := p.obs
+	return &obs, nil
+}
+
+func newTestReconciler(provider WeatherProvider, objs ...client.Object) (*WeatherReconciler, *record.FakeRecorder) {
+	scheme := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	Expect(weatherv1.AddToScheme(scheme)).To(Succeed())
+	recorder := record.NewFakeRecorder(10)
+	return &WeatherReconciler{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+		Scheme:    scheme,
+		Recorder:  recorder,
+		Providers: map[string]WeatherProvider{provider.Name(): provider},
+	}, recorder
+}
+
+func newTestWeather() *weatherv1.Weather {
+	return &weatherv1.Weather{
+		ObjectMeta: metav1.ObjectMeta{Name: "sample", Namespace: "default"},
+		Spec: weatherv1.WeatherSpec{
+			Lat:           "38.44",
+			Lon:           "-77.98",
+			SecretRef:     &weatherv1.SecretRefSpec{Name: "weather-api-secret", Key: "token"},
+			RefreshPeriod: "3m",
+			Provider:      "fake",
+		},
+	}
+}
+
+func newTestSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "weather-api-secret", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte("secret-token")},
+	}
+}
+
+var _ = Describe("WeatherReconciler", func() {
+	var (
+		ctx      context.Context
+		provider *fakeProvider
+		key      types.NamespacedName
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "sample"}
+		provider = &fakeProvider{obs: Observation{
+			Time:         time.Unix(1650000000, 0),
+			CountryCode:  "US",
+			LocationName: "Culpeper",
+			Temp:         61.5,
+			Pressure:     int64Ptr(1015),
+			Humidity:     int64Ptr(40),
+			WindSpeed:    float64Ptr(5.75),
+			WindGust:     float64Ptr(12.1),
+		}}
+	})
+
+	It("writes the provider observation into the status", func() {
+		r, recorder := newTestReconciler(provider, newTestWeather(), newTestSecret())
+
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(BeNumerically("~", 3*time.Minute, time.Second))
+
+		Expect(provider.requests).To(ConsistOf(ObservationRequest{Lat: "38.44", Lon: "-77.98", Units: UnitsImperial, Token: "secret-token"}))
+
+		weather := &weatherv1.Weather{}
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Temp).To(Equal(&weatherv1.Measurement{Value: 61.5, Unit: "degF"}))
+		Expect(weather.Status.Pressure).To(Equal(&weatherv1.Measurement{Value: 1015, Unit: "hPa"}))
+		Expect(weather.Status.Humidity).To(Equal(&weatherv1.Measurement{Value: 40, Unit: "%"}))
+		Expect(weather.Status.WindSpeed).To(Equal(&weatherv1.Measurement{Value: 5.75, Unit: "mph"}))
+		Expect(weather.Status.WindGust).To(Equal(&weatherv1.Measurement{Value: 12.1, Unit: "mph"}))
+		Expect(weather.Status.RefreshTime.Unix()).To(Equal(int64(1650000000)))
+		Expect(weather.Status.LocationName).To(Equal("Culpeper"))
+		Expect(weather.Status.CountryCode).To(Equal("US"))
+		Expect(weather.Status.Units).To(Equal(UnitsImperial))
+
+		Expect(recorder.Events).To(Receive(ContainSubstring("Weather changed.")))
+	})
+
+	It("writes the optional parts of the observation into the status", func() {
+		rain := 0.254
+		provider.obs.FeelsLike = float64Ptr(59.456)
+		provider.obs.Visibility = float64Ptr(10000)
+		provider.obs.WindDeg = float64Ptr(250)
+		provider.obs.Condition = &Condition{Id: 500, Main: "Rain", Description: "light rain", Icon: "10d"}
+		provider.obs.Sunrise = time.Unix(1649975000, 0)
+		provider.obs.Rain = &PrecipitationVolume{LastHour: &rain}
+		r, _ := newTestReconciler(provider, newTestWeather(), newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		weather := &weatherv1.Weather{}
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.FeelsLike).To(Equal(&weatherv1.Measurement{Value: 59.46, Unit: "degF"}))
+		Expect(weather.Status.TempMin).To(BeNil())
+		Expect(weather.Status.Visibility).To(Equal(&weatherv1.Measurement{Value: 10000, Unit: "m"}))
+		Expect(weather.Status.WindDirection).To(Equal(&weatherv1.WindDirection{Degrees: 250, Cardinal: "WSW"}))
+		Expect(weather.Status.Condition).To(Equal(&weatherv1.WeatherCondition{Id: 500, Main: "Rain", Description: "light rain", Icon: "10d"}))
+		Expect(weather.Status.Sunrise.Unix()).To(Equal(int64(1649975000)))
+		Expect(weather.Status.Sunset).To(BeNil())
+		Expect(weather.Status.Rain).To(Equal(&weatherv1.Precipitation{LastHour: &weatherv1.Measurement{Value: 0.25, Unit: "mm"}}))
+		Expect(weather.Status.Snow).To(BeNil())
+	})
+
+	It("keeps the previous pressure, humidity and wind when the provider does not report them", func() {
+		weather := newTestWeather()
+		weather.Status.Pressure = &weatherv1.Measurement{Value: 1012, Unit: "hPa"}
+		weather.Status.Humidity = &weatherv1.Measurement{Value: 55, Unit: "%"}
+		weather.Status.WindSpeed = &weatherv1.Measurement{Value: 4, Unit: "mph"}
+		weather.Status.WindGust = &weatherv1.Measurement{Value: 9, Unit: "mph"}
+		provider.obs.Pressure, provider.obs.Humidity = nil, nil
+		provider.obs.WindSpeed, provider.obs.WindGust = nil, nil
+		r, recorder := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Pressure).To(Equal(&weatherv1.Measurement{Value: 1012, Unit: "hPa"}))
+		Expect(weather.Status.Humidity).To(Equal(&weatherv1.Measurement{Value: 55, Unit: "%"}))
+		Expect(weather.Status.WindSpeed).To(Equal(&weatherv1.Measurement{Value: 4, Unit: "mph"}))
+		Expect(weather.Status.WindGust).To(Equal(&weatherv1.Measurement{Value: 9, Unit: "mph"}))
+		Expect(recorder.Events).To(Receive(Equal("Normal Updated Weather changed. [Temp]")))
+	})
+
+	It("maps wind directions to the nearest compass point", func() {
+		Expect(cardinal(0)).To(Equal("N"))
+		Expect(cardinal(11)).To(Equal("N"))
+		Expect(cardinal(12)).To(Equal("NNE"))
+		Expect(cardinal(180)).To(Equal("S"))
+		Expect(cardinal(349)).To(Equal("N"))
+		Expect(cardinal(360)).To(Equal("N"))
+	})
+
+	It("sets the Ready, SecretResolved, ProviderReachable and Stale conditions", func() {
+		weather := newTestWeather()
+		weather.Generation = 3
+		r, _ := newTestReconciler(provider, weather, newTestSecret())
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.ObservedGeneration).To(Equal(int64(3)))
+		Expect(meta.IsStatusConditionTrue(weather.Status.Conditions, weatherv1.ConditionReady)).To(BeTrue())
+		Expect(meta.IsStatusConditionTrue(weather.Status.Conditions, weatherv1.ConditionSecretResolved)).To(BeTrue())
+		Expect(meta.IsStatusConditionTrue(weather.Status.Conditions, weatherv1.ConditionProviderReachable)).To(BeTrue())
+		Expect(meta.IsStatusConditionFalse(weather.Status.Conditions, weatherv1.ConditionStale)).To(BeTrue())
+		Expect(meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionReady).ObservedGeneration).To(Equal(int64(3)))
+	})
+
+	It("reports the direction of changed measurements", func() {
+		weather := newTestWeather()
+		weather.Status.Units = UnitsImperial
+		weather.Status.Temp = &weatherv1.Measurement{Value: 70, Unit: "degF"}
+		weather.Status.Pressure = &weatherv1.Measurement{Value: 1000, Unit: "hPa"}
+		weather.Status.Humidity = &weatherv1.Measurement{Value: 40, Unit: "%"}
+		weather.Status.WindSpeed = &weatherv1.Measurement{Value: 5.75, Unit: "mph"}
+		weather.Status.WindGust = &weatherv1.Measurement{Value: 12.1, Unit: "mph"}
+		r, recorder := newTestReconciler(provider, weather, newTestSecret())
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Events).To(Receive(Equal("Normal Updated Weather changed. [Temp-, Pressure+]")))
+	})
+
+	It("passes the requested unit system to the provider", func() {
+		weather := newTestWeather()
+		weather.Spec.Units = UnitsMetric
+		weather.Status.Units = UnitsImperial
+		weather.Status.Temp = &weatherv1.Measurement{Value: 70, Unit: "degF"}
+		r, recorder := newTestReconciler(provider, weather, newTestSecret())
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(HaveLen(1))
+		Expect(provider.requests[0].Units).To(Equal(UnitsMetric))
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(weather.Status.Units).To(Equal(UnitsMetric))
+		Expect(weather.Status.Temp.Unit).To(Equal("degC"))
+		// readings in different unit systems have no direction
+		Expect(recorder.Events).To(Receive(ContainSubstring("[Temp, Pressure")))
+	})
+
+	It("records an event when the provider fails", func() {
+		provider.err = context.DeadlineExceeded
+		r, recorder := newTestReconciler(provider, newTestWeather(), newTestSecret())
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).To(HaveOccurred())
+		Expect(recorder.Events).To(Receive(Equal("Warning ProviderError Unable to query weather API: the provider is unavailable")))
+
+		weather := &weatherv1.Weather{}
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(meta.IsStatusConditionFalse(weather.Status.Conditions, weatherv1.ConditionReady)).To(BeTrue())
+		Expect(meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionProviderReachable).Reason).To(Equal(ReasonProviderError))
+		Expect(meta.IsStatusConditionTrue(weather.Status.Conditions, weatherv1.ConditionStale)).To(BeTrue())
+	})
+
+	It("waits for the provider rate limit to reset instead of failing", func() {
+		provider.err = &RateLimitedError{Provider: "fake", RetryAfter: 42 * time.Second}
+		r, _ := newTestReconciler(provider, newTestWeather(), newTestSecret())
+
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(42 * time.Second))
+
+		weather := &weatherv1.Weather{}
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionProviderReachable).Reason).To(Equal(ReasonRateLimited))
+
+		// the status must not change with the delay, or writing it would trigger the next reconcile right away
+		ready := meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionReady).DeepCopy()
+		provider.err = &RateLimitedError{Provider: "fake", RetryAfter: 41 * time.Second}
+		result, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(41 * time.Second))
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		Expect(meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionReady)).To(Equal(ready))
+	})
+
+	It("defers calls once the rate limiter is exhausted", func() {
+		other := newTestWeather()
+		other.Name = "other"
+		r, _ := newTestReconciler(provider, newTestWeather(), other, newTestSecret())
+		r.RateLimiter = NewRateLimiter(0, 1)
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(other)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(BeNumerically("~", time.Minute, time.Second))
+		Expect(provider.requests).To(HaveLen(1))
+	})
+
+	It("skips the fetch until refreshPeriod after the last fetch", func() {
+		weather := newTestWeather()
+		lastFetch := metav1.NewTime(time.Now().Add(-time.Minute))
+		weather.Status.LastFetchTime = &lastFetch
+		setRefreshed(weather, "fake")
+		r, _ := newTestReconciler(provider, weather, newTestSecret())
+
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(BeEmpty())
+		Expect(result.RequeueAfter).To(BeNumerically("~", 2*time.Minute, time.Second))
+	})
+
+	It("fetches early when the spec changed or the last refresh failed", func() {
+		weather := newTestWeather()
+		lastFetch := metav1.NewTime(time.Now().Add(-time.Minute))
+		weather.Status.LastFetchTime = &lastFetch
+		setRefreshed(weather, "fake")
+		weather.Generation = 2
+		r, _ := newTestReconciler(provider, weather, newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(HaveLen(1))
+
+		weather = newTestWeather()
+		weather.Status.LastFetchTime = &lastFetch
+		setCondition(weather, weatherv1.ConditionReady, metav1.ConditionFalse, ReasonProviderError, "")
+		r, _ = newTestReconciler(provider, weather, newTestSecret())
+		_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(HaveLen(2))
+	})
+
+	It("spreads fetches with a stable jitter of up to RefreshJitter", func() {
+		r, _ := newTestReconciler(provider)
+		r.RefreshJitter = 0.5
+		seen := map[time.Duration]bool{}
+		for _, name := range []string{"a", "b", "c", "d"} {
+			weather := newTestWeather()
+			weather.Name = name
+			jitter := r.jitter(weather, time.Minute)
+			Expect(jitter).To(BeNumerically(">=", 0))
+			Expect(jitter).To(BeNumerically("<=", 30*time.Second))
+			Expect(r.jitter(weather, time.Minute)).To(Equal(jitter))
+			seen[jitter] = true
+		}
+		Expect(len(seen)).To(BeNumerically(">", 1))
+
+		r.RefreshJitter = 0
+		Expect(r.jitter(newTestWeather(), time.Minute)).To(BeZero())
+	})
+
+	It("delays the first fetch of a weather by its jitter, then fetches every refreshPeriod", func() {
+		var objs []client.Object
+		for _, name := range []string{"a", "b", "c", "d"} {
+			weather := newTestWeather()
+			weather.Name, weather.UID, weather.Generation = name, types.UID("uid-"+name), 1
+			objs = append(objs, weather)
+		}
+		r, _ := newTestReconciler(provider, append(objs, newTestSecret())...)
+		r.RefreshJitter = 0.5
+
+		seen := map[time.Duration]bool{}
+		for _, obj := range objs {
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(obj)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+			Expect(result.RequeueAfter).To(BeNumerically("<=", 90*time.Second))
+			seen[result.RequeueAfter.Round(time.Second)] = true
+		}
+		Expect(len(seen)).To(BeNumerically(">", 1))
+		Expect(provider.requests).To(BeEmpty())
+
+		// the first fetch stays due at the same time across reconciles
+		key := types.NamespacedName{Namespace: "default", Name: "a"}
+		defer forgetWeatherMetrics(key)
+		first := r.firstFetches[key]
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(BeNumerically("~", time.Until(first.due), time.Second))
+
+		first.due = time.Now().Add(-time.Second)
+		r.firstFetches[key] = first
+		result, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(HaveLen(1))
+		Expect(result.RequeueAfter).To(BeNumerically("~", 3*time.Minute, time.Second))
+		Expect(r.firstFetches).NotTo(HaveKey(key))
+
+		// later fetches keep a strict refreshPeriod cadence from the last fetch
+		weather := &weatherv1.Weather{}
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		lastFetch := metav1.NewTime(time.Now().Add(-time.Minute))
+		weather.Status.LastFetchTime = &lastFetch
+		Expect(r.Client.Status().Update(ctx, weather)).To(Succeed())
+		result, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(HaveLen(1))
+		Expect(result.RequeueAfter).To(BeNumerically("~", 2*time.Minute, time.Second))
+
+		// a spec change delays the first fetch of the new generation again
+		weather.Generation = 2
+		Expect(r.Client.Update(ctx, weather)).To(Succeed())
+		result, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(HaveLen(1))
+		Expect(result.RequeueAfter).To(BeNumerically("~", r.jitter(weather, 3*time.Minute), time.Second))
+	})
+
+	table.DescribeTable("maps provider errors to a condition reason and retry policy",
+		func(providerErr error, reason string, retry bool, requeueAfter time.Duration) {
+			provider.err = providerErr
+			r, recorder := newTestReconciler(provider, newTestWeather(), newTestSecret())
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+			Expect(err != nil).To(Equal(retry))
+			Expect(result.RequeueAfter).To(Equal(requeueAfter))
+			Expect(recorder.Events).To(Receive(HavePrefix("Warning " + reason + " ")))
+
+			weather := &weatherv1.Weather{}
+			Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+			Expect(meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionProviderReachable).Reason).To(Equal(reason))
+			Expect(meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionReady).Reason).To(Equal(reason))
+		},
+		table.Entry("rejected token", statusCodeError("fake", http.StatusUnauthorized), ReasonUnauthorized, false, time.Duration(0)),
+		table.Entry("rejected location", statusCodeError("fake", http.StatusNotFound), ReasonNotFound, false, time.Duration(0)),
+		table.Entry("server error", statusCodeError("fake", http.StatusBadGateway), ReasonProviderError, true, time.Duration(0)),
+		table.Entry("network error", transientError("fake", errors.New("connection refused")), ReasonProviderError, true, time.Duration(0)),
+		table.Entry("unclassified error", errors.New("boom"), ReasonProviderError, true, time.Duration(0)),
+		table.Entry("unparseable response", decodeError("fake", errors.New("unexpected EOF")), ReasonDecodeFailed, false, 3*time.Minute),
+		table.Entry("rate limited", &RateLimitedError{Provider: "fake", RetryAfter: 30 * time.Second}, ReasonRateLimited, false, 30*time.Second),
+	)
+
+	It("parks weathers whose token was rejected until the spec or secret changes", func() {
+		provider.err = statusCodeError("fake", http.StatusUnauthorized)
+		r, _ := newTestReconciler(provider, newTestWeather(), newTestSecret())
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(HaveLen(1))
+
+		// e.g. a label change or resync
+		_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(HaveLen(1))
+
+		secret := &corev1.Secret{}
+		Expect(r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "weather-api-secret"}, secret)).To(Succeed())
+		secret.Data["token"] = []byte("new-token")
+		Expect(r.Client.Update(ctx, secret)).To(Succeed())
+		provider.err = nil
+		_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(HaveLen(2))
+		Expect(provider.requests[1].Token).To(Equal("new-token"))
+
+		provider.err = statusCodeError("fake", http.StatusNotFound)
+		weather := &weatherv1.Weather{}
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		weather.Status.LastFetchTime = nil
+		Expect(r.Client.Status().Update(ctx, weather)).To(Succeed())
+		_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(HaveLen(3))
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		weather.Spec.Lat = "38.45"
+		weather.Generation++
+		Expect(r.Client.Update(ctx, weather)).To(Succeed())
+		_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(HaveLen(4))
+	})
+
+	It("reports a missing secret in the SecretResolved condition", func() {
+		r, _ := newTestReconciler(provider, newTestWeather())
+
+		// the secret watch requeues the weather once the secret is created
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{}))
+
+		weather := &weatherv1.Weather{}
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		cond := meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionSecretResolved)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+		Expect(cond.Reason).To(Equal(ReasonSecretNotFound))
+		Expect(meta.IsStatusConditionFalse(weather.Status.Conditions, weatherv1.ConditionReady)).To(BeTrue())
+	})
+
+	It("reads the token from spec.secretRef.key", func() {
+		weather := newTestWeather()
+		weather.Spec.SecretRef.Key = "openweathermap"
+		secret := newTestSecret()
+		secret.Data["openweathermap"] = []byte("owm-token")
+		r, _ := newTestReconciler(provider, weather, secret)
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(HaveLen(1))
+		Expect(provider.requests[0].Token).To(Equal("owm-token"))
+	})
+
+	It("reports a missing secret key in the SecretResolved condition", func() {
+		weather := newTestWeather()
+		weather.Spec.SecretRef.Key = "nws"
+		r, recorder := newTestReconciler(provider, weather, newTestSecret())
+
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{}))
+		Expect(provider.requests).To(BeEmpty())
+		Expect(recorder.Events).To(Receive(ContainSubstring("does not have a 'nws' attribute")))
+
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		cond := meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionSecretResolved)
+		Expect(cond.Reason).To(Equal(ReasonSecretKeyMissing))
+	})
+
+	It("only reads secrets from other namespaces when allowed", func() {
+		weather := newTestWeather()
+		weather.Spec.SecretRef.Namespace = "shared-secrets"
+		secret := newTestSecret()
+		secret.Namespace = "shared-secrets"
+		r, _ := newTestReconciler(provider, weather, secret)
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(BeEmpty())
+		Expect(r.Client.Get(ctx, key, weather)).To(Succeed())
+		cond := meta.FindStatusCondition(weather.Status.Conditions, weatherv1.ConditionSecretResolved)
+		Expect(cond.Reason).To(Equal(ReasonSecretNamespaceNotAllowed))
+
+		r.SecretNamespaces = []string{"shared-secrets"}
+		_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(HaveLen(1))
+		Expect(provider.requests[0].Token).To(Equal("secret-token"))
+	})
+
+	It("maps a changed secret to the weathers referencing it", func() {
+		other := newTestWeather()
+		other.Name = "other"
+		other.Spec.SecretRef.Name = "other-secret"
+		shared := newTestWeather()
+		shared.Name = "shared"
+		shared.Namespace = "edge"
+		shared.Spec.SecretRef.Namespace = "default"
+		tokenless := newTestWeather()
+		tokenless.Name = "tokenless"
+		tokenless.Spec.SecretRef = nil
+		r, _ := newTestReconciler(provider, newTestWeather(), other, shared, tokenless)
+
+		Expect(r.weathersForSecret(newTestSecret())).To(ConsistOf(
+			reconcile.Request{NamespacedName: key},
+			reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "edge", Name: "shared"}},
+		))
+		Expect(secretRefName(newTestWeather())).To(Equal([]string{"weather-api-secret"}))
+		Expect(secretRefName(tokenless)).To(BeEmpty())
+	})
+
+	It("does not need a secret for tokenless providers", func() {
+		provider.tokenless = true
+		weather := newTestWeather()
+		weather.Spec.SecretRef = nil
+		r, _ := newTestReconciler(provider, weather)
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(ConsistOf(ObservationRequest{Lat: "38.44", Lon: "-77.98", Units: UnitsImperial}))
+	})
+
+	It("requires a secretRef for providers that need a token", func() {
+		weather := newTestWeather()
+		weather.Spec.SecretRef = nil
+		r, recorder := newTestReconciler(provider, weather)
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.requests).To(BeEmpty())
+		Expect(recorder.Events).To(Receive(ContainSubstring("requires spec.secretRef")))
+	})
+
+	It("rejects an unknown provider without retrying", func() {
+		weather := newTestWeather()
+		weather.Spec.Provider = "unknown"
+		r, recorder := newTestReconciler(provider, weather, newTestSecret())
+
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Requeue).To(BeFalse())
+		Expect(provider.requests).To(BeEmpty())
+		Expect(recorder.Events).To(Receive(ContainSubstring("Unknown weather provider")))
+	})
+})
@@ -19,6 +19,8 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -31,6 +33,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	weatherv1 "alsup/api/v1"
 	weatherv1beta1 "alsup/api/v1beta1"
 	"alsup/controllers"
 	//+kubebuilder:scaffold:imports
@@ -45,6 +48,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(weatherv1beta1.AddToScheme(scheme))
+	utilruntime.Must(weatherv1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -52,11 +56,28 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var secretNamespaces string
+	var cacheTTL time.Duration
+	var rateLimit int
+	var secretRateLimit int
+	var refreshJitter float64
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&secretNamespaces, "secret-namespaces", "",
+		"Comma-separated namespaces a Weather may reference in spec.secretRef.namespace, besides its own. "+
+			"Use '*' to allow any namespace.")
+	flag.DurationVar(&cacheTTL, "observation-cache-ttl", controllers.DefaultObservationCacheTTL,
+		"How long an observation is shared between Weathers for the same place. Use 0 to disable the cache.")
+	flag.IntVar(&rateLimit, "rate-limit", controllers.DefaultRateLimit,
+		"Maximum calls per minute to each weather provider. Use 0 for no limit.")
+	flag.IntVar(&secretRateLimit, "secret-rate-limit", controllers.DefaultSecretRateLimit,
+		"Maximum calls per minute with each API token secret, unless overridden by its "+
+			controllers.RateLimitAnnotation+" annotation. Use 0 for no limit.")
+	flag.Float64Var(&refreshJitter, "refresh-jitter", controllers.DefaultRefreshJitter,
+		"Fraction of its refreshPeriod (0 to 1) by which the first fetch of each Weather is delayed, to spread out fetches.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -79,12 +100,23 @@ func main() {
 	}
 
 	if err = (&controllers.WeatherReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:           mgr.GetClient(),
+		Scheme:           mgr.GetScheme(),
+		SecretNamespaces: splitList(secretNamespaces),
+		Cache:            controllers.NewObservationCache(cacheTTL),
+		RateLimiter:      controllers.NewRateLimiter(rateLimit, secretRateLimit),
+		RefreshJitter:    refreshJitter,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Weather")
 		os.Exit(1)
 	}
+	// the webhook server needs serving certificates, which are usually missing when running locally
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&weatherv1.Weather{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Weather")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -102,3 +134,14 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}